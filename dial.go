@@ -0,0 +1,203 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/net/proxy"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// Port of HTTP proxies whose URL doesn't contain a port.
+const httpProxyPort = "80"
+
+// dialer establishes connections according to the proxy, bind address
+// and IP version configured for a network.
+type dialer struct {
+	direct  net.Dialer
+	proxy   *url.URL
+	prefer  int
+	timeout time.Duration
+}
+
+func newDialer(config config) (*dialer, error) {
+	timeout, err := time.ParseDuration(config.ConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &dialer{prefer: config.PreferIP, timeout: timeout}
+	if d.prefer != 0 && d.prefer != 4 && d.prefer != 6 {
+		return nil, fmt.Errorf("invalid IP version %d", d.prefer)
+	}
+
+	if len(config.Bind) >= 1 {
+		ip := net.ParseIP(config.Bind)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid bind address %q", config.Bind)
+		}
+		d.direct.LocalAddr = &net.TCPAddr{IP: ip}
+
+		// The address family is determined by the bind address.
+		d.prefer = 6
+		if ip.To4() != nil {
+			d.prefer = 4
+		}
+	}
+
+	if len(config.Proxy) >= 1 {
+		if d.proxy, err = url.Parse(config.Proxy); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// open connects to the given address, possibly through the proxy.
+func (d *dialer) open(ctx context.Context, addr string) (net.Conn, error) {
+	if d.proxy == nil {
+		return d.DialContext(ctx, "tcp", addr)
+	}
+
+	switch d.proxy.Scheme {
+	case "socks5", "socks5h":
+		p, err := proxy.FromURL(d.proxy, d)
+		if err != nil {
+			return nil, err
+		}
+
+		return p.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+	case "http":
+		return d.dialHTTP(ctx, addr)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", d.proxy.Scheme)
+	}
+}
+
+// DialContext connects directly to the given address. If the address
+// resolves to multiple IPs those of the preferred version are tried
+// first. It implements proxy.ContextDialer.
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.prefer == 0 {
+		return d.direct.DialContext(ctx, network, addr)
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(ips, func(i, j int) bool {
+		return d.preferred(ips[i].IP) && !d.preferred(ips[j].IP)
+	})
+
+	err = fmt.Errorf("no suitable address found for %q", host)
+	for _, ip := range ips {
+		// A bind address only works with its own address family.
+		if d.direct.LocalAddr != nil && !d.preferred(ip.IP) {
+			continue
+		}
+
+		var conn net.Conn
+		conn, err = d.direct.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// Dial implements proxy.Dialer.
+func (d *dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d *dialer) preferred(ip net.IP) bool {
+	return (ip.To4() != nil) == (d.prefer == 4)
+}
+
+// dialHTTP connects to the given address using an HTTP CONNECT proxy.
+func (d *dialer) dialHTTP(ctx context.Context, addr string) (net.Conn, error) {
+	proxyAddr := d.proxy.Host
+	if len(d.proxy.Port()) <= 0 {
+		proxyAddr = net.JoinHostPort(d.proxy.Hostname(), httpProxyPort)
+	}
+
+	conn, err := d.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := d.proxy.User; user != nil {
+		password, _ := user.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused connection: %s", resp.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return &bufferedConn{conn, reader}, nil
+}
+
+// bufferedConn is a connection whose beginning was already read into
+// a buffer, e.g. while reading the response of a proxy.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,93 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// httpProxy accepts a single CONNECT request and answers it using the
+// given status. Afterwards it sends a line on behalf of the server.
+func httpProxy(t *testing.T, status int) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || req.Method != http.MethodConnect {
+			return
+		}
+
+		fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", status, http.StatusText(status))
+		fmt.Fprintf(conn, ":irc.example.org NOTICE * :hello\r\n")
+	}()
+
+	return l
+}
+
+func TestHTTPProxy(t *testing.T) {
+	tests := []struct {
+		status int
+		ok     bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusNoContent, true},
+		{http.StatusProxyAuthRequired, false},
+		{http.StatusBadGateway, false},
+	}
+
+	for _, test := range tests {
+		l := httpProxy(t, test.status)
+		defer l.Close()
+
+		conf := confDefaults()
+		conf.Proxy = "http://" + l.Addr().String()
+
+		d, err := newDialer(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := d.open(context.Background(), "irc.example.org:6667")
+		if !test.ok {
+			if err == nil {
+				conn.Close()
+				t.Errorf("%d: connection wasn't refused", test.status)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%d: %s", test.status, err)
+			continue
+		}
+
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil || line != ":irc.example.org NOTICE * :hello\r\n" {
+			t.Errorf("%d: read %q, %v", test.status, line, err)
+		}
+		conn.Close()
+	}
+}
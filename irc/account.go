@@ -0,0 +1,125 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// Token of the WHOX queries used for learning accounts.
+	whoxToken = "741"
+
+	// Time to wait for the reply to a WHOX query.
+	whoxTimeout = time.Minute
+)
+
+// Account returns the services account of the given nick if it is
+// known. The account is empty if the user isn't logged in. Accounts
+// are only tracked for users sharing a channel with the client.
+func (c *Client) Account(nick string) (string, bool) {
+	c.state.mtx.RLock()
+	defer c.state.mtx.RUnlock()
+
+	account, ok := c.state.accounts[c.state.fold(nick)]
+	return account, ok
+}
+
+// setAccount records the account of the given nick if it shares a
+// channel with the client. The account "*" or "0" means that the user
+// isn't logged in.
+func (c *Client) setAccount(nick, account string) {
+	if account == "*" || account == "0" {
+		account = ""
+	}
+
+	s := c.state
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	key := s.fold(nick)
+	for _, ch := range s.channels {
+		if _, ok := ch.members[key]; ok {
+			s.accounts[key] = account
+			return
+		}
+	}
+}
+
+// messageAccount returns the account of the sender of the given
+// message according to the account tag or the tracked accounts.
+func (c *Client) messageAccount(msg Message) string {
+	if account, ok := msg.Tags["account"]; ok {
+		return account
+	}
+
+	account, _ := c.Account(msg.Sender.Name)
+	return account
+}
+
+// trackAccount updates the account of the sender of any message sent
+// by a user if account-tag is enabled. ACCOUNT messages are handled
+// by accountCmd instead.
+func (c *Client) trackAccount(msg Message) {
+	if len(msg.Sender.User) <= 0 || msg.Command == "account" || !c.HasCap("account-tag") {
+		return
+	}
+
+	// The tag is omitted if the user isn't logged in.
+	c.setAccount(msg.Sender.Name, msg.Tags["account"])
+}
+
+// accountCmd handles ACCOUNT messages sent with account-notify.
+func accountCmd(client *Client, msg Message) error {
+	client.setAccount(msg.Sender.Name, msg.Receiver)
+	return nil
+}
+
+// accountJoinCmd learns the account of users joining a channel from
+// extended-join and the accounts of all users in a channel joined by
+// the client using WHOX, if supported.
+func accountJoinCmd(client *Client, msg Message) error {
+	if !client.EqualFold(msg.Sender.Name, client.Nick()) {
+		if len(msg.Params) >= 3 {
+			client.setAccount(msg.Sender.Name, msg.Params[1])
+		}
+		return nil
+	}
+
+	if _, ok := client.ISupport("WHOX"); ok {
+		go client.whoxAccounts(msg.Receiver)
+	}
+	return nil
+}
+
+// whoxAccounts learns the accounts of all users in the given channel.
+func (c *Client) whoxAccounts(channel string) {
+	ctx, cancel := context.WithTimeout(c.ctx, whoxTimeout)
+	defer cancel()
+
+	// Requested fields: token, nick and account.
+	q := newQuery("", []string{"354"}, []string{"315"}, nil)
+	q.mask = channel
+	msgs, err := c.query(ctx, q, "WHO %s %%tna,%s", channel, whoxToken)
+	if err != nil {
+		return
+	}
+
+	for _, msg := range msgs {
+		if msg.Param(1) == whoxToken {
+			c.setAccount(msg.Param(2), msg.Param(3))
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"sort"
+	"strings"
+)
+
+// Capabilities requested by default if offered by the server.
+var defaultCaps = []string{
+	"server-time",
+	"message-tags",
+	"account-tag",
+	"account-notify",
+	"extended-join",
+	"away-notify",
+	"multi-prefix",
+	"echo-message",
+	"cap-notify",
+	"batch",
+	"labeled-response",
+}
+
+type capState struct {
+	// Capabilities offered by the server and their values.
+	available map[string]string
+
+	// Capabilities acknowledged by the server.
+	enabled map[string]bool
+
+	// Additional capabilities requested by modules.
+	extra []string
+
+	// Amount of CAP REQ commands not yet answered by the server.
+	pending int
+
+	// Whether CAP END was already sent for this connection.
+	done bool
+
+	// Whether SASL authentication succeeded on this connection.
+	authenticated bool
+}
+
+func (s *capState) reset() {
+	s.available = make(map[string]string)
+	s.enabled = make(map[string]bool)
+	s.pending = 0
+	s.done = false
+	s.authenticated = false
+}
+
+// wanted returns all capabilities offered by the server which should
+// be requested and haven't been acknowledged yet.
+func (s *capState) wanted() []string {
+	var caps []string
+	seen := make(map[string]bool)
+	for _, name := range append(defaultCaps, s.extra...) {
+		if _, ok := s.available[name]; !ok || s.enabled[name] || seen[name] {
+			continue
+		}
+
+		seen[name] = true
+		caps = append(caps, name)
+	}
+
+	return caps
+}
+
+// add adds the capabilities from the given space separated list of
+// capabilities with optional values to the available capabilities.
+func (s *capState) add(list string) {
+	for _, entry := range strings.Fields(list) {
+		name, value := entry, ""
+		if idx := strings.Index(entry, "="); idx >= 0 {
+			name, value = entry[:idx], entry[idx+1:]
+		}
+
+		s.available[name] = value
+	}
+}
+
+// RequestCap adds the given capabilities to the list of capabilities
+// requested during registration. Capabilities requested after the
+// registration completed are requested immediately if the server
+// offers them.
+func (c *Client) RequestCap(names ...string) error {
+	c.capMtx.Lock()
+	defer c.capMtx.Unlock()
+
+	c.caps.extra = append(c.caps.extra, names...)
+	if !c.caps.done {
+		return nil
+	}
+
+	return c.requestCaps()
+}
+
+// HasCap reports whether the given capability was acknowledged by
+// the server.
+func (c *Client) HasCap(name string) bool {
+	c.capMtx.Lock()
+	defer c.capMtx.Unlock()
+
+	return c.caps.enabled[name]
+}
+
+// Caps returns a sorted list of all capabilities acknowledged by the
+// server.
+func (c *Client) Caps() []string {
+	c.capMtx.Lock()
+	defer c.capMtx.Unlock()
+
+	var caps []string
+	for name, ok := range c.caps.enabled {
+		if ok {
+			caps = append(caps, name)
+		}
+	}
+
+	sort.Strings(caps)
+	return caps
+}
+
+// requestCaps sends a CAP REQ for all wanted capabilities. SASL is
+// requested on its own since the server refuses the whole request if
+// it refuses any capability. The caller must hold the capMtx lock.
+func (c *Client) requestCaps() error {
+	var caps []string
+	for _, name := range c.caps.wanted() {
+		if name != "sasl" {
+			caps = append(caps, name)
+		} else if err := c.sendReq([]string{name}); err != nil {
+			return err
+		}
+	}
+
+	return c.sendReq(caps)
+}
+
+// sendReq sends a CAP REQ for the given capabilities, if any. The
+// caller must hold the capMtx lock.
+func (c *Client) sendReq(caps []string) error {
+	if len(caps) <= 0 {
+		return nil
+	}
+
+	c.caps.pending++
+	return c.Write("CAP REQ :%s", strings.Join(caps, " "))
+}
+
+// endCaps finishes capability negotiation if there are no outstanding
+// requests. The caller must hold the capMtx lock.
+func (c *Client) endCaps() error {
+	if c.caps.done || c.caps.pending > 0 {
+		return nil
+	}
+
+	c.caps.done = true
+	return c.Write("CAP END")
+}
+
+func capCmd(client *Client, msg Message) error {
+	client.capMtx.Lock()
+	defer client.capMtx.Unlock()
+
+	state := &client.caps
+	switch strings.ToUpper(msg.Param(1)) {
+	case "LS":
+		state.add(msg.Data)
+
+		// Multi-line replies contain an asterisk before the list.
+		if msg.Param(2) == "*" && len(msg.Params) > 3 || state.done {
+			return nil
+		}
+
+		// An STS policy advertised on an insecure connection requires
+		// reconnecting using TLS before completing the registration.
+		if policy, ok := client.sts(); ok && policy.Port > 0 && !client.Secure() {
+			return client.disconnect()
+		}
+
+		if err := client.checkSASL(); err != nil {
+			return err
+		} else if err := client.requestCaps(); err != nil {
+			return err
+		}
+		return client.endCaps()
+	case "ACK":
+		for _, name := range strings.Fields(msg.Data) {
+			if strings.HasPrefix(name, "-") {
+				delete(state.enabled, name[1:])
+				continue
+			}
+
+			state.enabled[name] = true
+			if name == "sasl" && client.sasl != nil && !state.done {
+				if err := client.startSASL(); err != nil {
+					return err
+				}
+			}
+		}
+
+		if state.pending > 0 {
+			state.pending--
+		}
+		return client.endCaps()
+	case "NAK":
+		if client.sasl != nil && msg.Data == "sasl" {
+			return client.abortSASL("server refused capability")
+		}
+		if state.pending > 0 {
+			state.pending--
+		}
+		return client.endCaps()
+	case "NEW":
+		state.add(msg.Data)
+		return client.requestCaps()
+	case "DEL":
+		for _, name := range strings.Fields(msg.Data) {
+			delete(state.available, name)
+			delete(state.enabled, name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"strings"
+	"unicode/utf8"
+)
+
+// Charset used for decoding lines which aren't valid UTF-8 if no
+// charset was configured.
+var fallbackCharset encoding.Encoding = charmap.Windows1252
+
+// charset returns the charset configured for the given target, nil
+// if UTF-8 should be used.
+func (c *Client) charset(target string) encoding.Encoding {
+	if len(c.ChannelCharsets) > 0 && c.IsChannel(target) {
+		for name, charset := range c.ChannelCharsets {
+			if c.EqualFold(name, target) {
+				return charset
+			}
+		}
+	}
+
+	return c.Charset
+}
+
+// decode converts the given line to UTF-8 using the charset of its
+// target if it isn't valid UTF-8 already.
+func (c *Client) decode(line string) string {
+	if utf8.ValidString(line) {
+		return line
+	}
+
+	// Parameters are separated by ASCII characters, thus the
+	// target can be determined before decoding the line.
+	charset := c.charset(parseMessage(line).Receiver)
+	if charset == nil {
+		charset = fallbackCharset
+	}
+
+	decoded, err := charset.NewDecoder().String(line)
+	if err != nil {
+		return strings.ToValidUTF8(line, string(utf8.RuneError))
+	}
+
+	return decoded
+}
+
+// encode converts the given line to the charset of its target.
+// Characters not representable in that charset are replaced.
+func (c *Client) encode(line string) string {
+	charset := c.charset(parseMessage(line).Receiver)
+	if charset == nil {
+		return line
+	}
+
+	encoded, err := encoding.ReplaceUnsupported(charset.NewEncoder()).String(line)
+	if err != nil {
+		return line
+	}
+
+	return encoded
+}
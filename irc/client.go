@@ -14,115 +14,380 @@
 package irc
 
 import (
+	"context"
 	"fmt"
+	"github.com/nmeum/marvin/irc/format"
+	"golang.org/x/text/encoding"
 	"net"
 	"strings"
+	"sync"
+	"time"
 	"unicode"
 )
 
 type Hook func(*Client, Message) error
 
+// Time after which writing a single line to the server is aborted.
+const writeTimeout = time.Minute
+
+// Commands which are always sent with a high priority.
+var highPriority = map[string]bool{
+	"PASS":         true,
+	"CAP":          true,
+	"AUTHENTICATE": true,
+	"NICK":         true,
+	"USER":         true,
+	"PING":         true,
+	"PONG":         true,
+	"QUIT":         true,
+}
+
 type Client struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
 	conn     net.Conn
-	hooks    map[string][]Hook
-	Nickname string
+	connMtx  sync.Mutex
+	queue    *queue
+	handlers map[string][]Hook
+	caps     capState
+	capMtx   sync.Mutex
+	sasl     *saslConfig
+	fatal    error
+	state    *state
+
+	// Own user and host as seen by the server.
+	user      string
+	host      string
+	prefixMtx sync.Mutex
+
+	// Lines buffered by Send for each target.
+	more    map[string]*buffered
+	moreMtx sync.Mutex
+
+	// Hooks registered using AddHook and CTCPHook.
+	hooks     map[string][]*hookEntry
+	ctcpHooks map[string][]CTCPHook
+	hookMtx   sync.RWMutex
+
+	// Pending queries and the last label sent with one.
+	queries  []*query
+	labels   int
+	queryMtx sync.Mutex
+
+	// Hooks started by Handle which didn't return yet.
+	running sync.WaitGroup
+
+	// Group and network name the client was added with.
+	group    *Group
+	network  string
+	groupMtx sync.Mutex
+
+	nick       nickState
+	nickMtx    sync.Mutex
+	regainOnce sync.Once
+
+	ping     pingState
+	pingMtx  sync.Mutex
+	pingOnce sync.Once
+
 	Realname string
-	Channels []string
+
+	// Nicknames tried in order if the nickname passed to Setup is
+	// unavailable during registration.
+	AltNicks []string
+
+	// Interval between attempts to regain the nickname passed to
+	// Setup if it was unavailable.
+	RegainInterval time.Duration
+
+	// Interval between PINGs sent to the server and time to wait
+	// for the corresponding PONG before closing the connection.
+	PingInterval time.Duration
+	PingTimeout  time.Duration
+
+	// Maximum amount of lines sent by Send at once, zero means
+	// unlimited. Further lines are buffered and sent by More.
+	MaxLines int
+
+	// Command mentioned if lines were buffered by Send. If MoreHint
+	// is set it returns the command used for the given target instead.
+	MoreCommand string
+	MoreHint    func(target string) string
+
+	// How replies sent using Reply are delivered and whether they
+	// are prefixed with the nickname of the sender in channels.
+	ReplyMode   ReplyMode
+	ReplyPrefix bool
+
+	// Version sent in replies to CTCP VERSION requests.
+	Version string
+
+	// CTCP requests answered by the client, other requests are only
+	// passed to the hooks registered using CTCPHook.
+	CTCPReplies []string
+
+	// Recorder for all lines sent and handled by the client, if any.
+	Recorder *Recorder
+
+	// Charset used for outgoing lines and for decoding incoming lines
+	// which aren't valid UTF-8, nil means UTF-8. The charsets of
+	// specific channels can be overwritten using ChannelCharsets.
+	Charset         encoding.Encoding
+	ChannelCharsets map[string]encoding.Encoding
 }
 
+// NewClient creates a new client using the given connection. If conn
+// is nil lines are dropped until a connection is passed to Reconnect.
 func NewClient(conn net.Conn) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
 	c := &Client{
-		conn:  conn,
-		hooks: make(map[string][]Hook),
+		ctx:       ctx,
+		cancel:    cancel,
+		conn:      conn,
+		queue:     newQueue(DefaultLimits),
+		hooks:     make(map[string][]*hookEntry),
+		handlers:  make(map[string][]Hook),
+		ctcpHooks: make(map[string][]CTCPHook),
+		more:      make(map[string]*buffered),
+		state:     newState(),
+
+		MoreCommand: "!more",
+		Version:     "marvin",
+		CTCPReplies: defaultCTCPReplies,
+	}
+	go c.writer()
+
+	c.protoHook("join", stateJoinCmd)
+	c.protoHook("part", statePartCmd)
+	c.protoHook("kick", stateKickCmd)
+	c.protoHook("quit", stateQuitCmd)
+	c.protoHook("nick", stateNickCmd)
+	c.protoHook("mode", stateModeCmd)
+	c.protoHook("topic", stateTopicCmd)
+	c.protoHook("324", stateModeCmd)
+	c.protoHook("332", stateTopicCmd)
+	c.protoHook("353", stateNamesCmd)
+	c.protoHook("005", isupportCmd)
+
+	c.protoHook("account", accountCmd)
+	c.protoHook("join", accountJoinCmd)
+
+	for _, cmd := range []string{"431", "432", "433", "436"} {
+		c.protoHook(cmd, nickInUseCmd)
 	}
+	c.protoHook("001", nickWelcomeCmd)
+	c.protoHook("nick", nickChangeCmd)
+	c.protoHook("quit", nickQuitCmd)
 
-	c.CmdHook("join", joinCmd)
-	c.CmdHook("part", partCmd)
-	c.CmdHook("kick", kickCmd)
+	c.protoHook("join", prefixCmd)
+	c.protoHook("396", hostCmd)
 
-	c.CmdHook("ping", pingCmd)
+	c.protoHook("cap", capCmd)
+	c.protoHook("authenticate", authenticateCmd)
+	for _, cmd := range []string{"903", "907"} {
+		c.protoHook(cmd, saslSuccessCmd)
+	}
+	for _, cmd := range []string{"902", "904", "905", "906"} {
+		c.protoHook(cmd, saslFailCmd)
+	}
+	c.protoHook("001", saslRegisteredCmd)
+
+	c.protoHook("ping", pingCmd)
+	c.protoHook("pong", pongCmd)
+	c.protoHook("001", pingWelcomeCmd)
+
+	c.CmdHook("privmsg", ctcpCmd)
+	c.CmdHook("notice", ctcpCmd)
 	return c
 }
 
 func (c *Client) Setup(nick, name, host string) {
-	c.Nickname = nick
+	c.nickMtx.Lock()
+	c.nick = nickState{current: nick, primary: nick}
+	c.nickMtx.Unlock()
+
 	c.Realname = name
 
-	c.Write("USER %s %s * :%s", c.Nickname, host, c.Realname)
-	c.Write("NICK %s", c.Nickname)
+	c.capMtx.Lock()
+	c.caps.reset()
+	c.capMtx.Unlock()
+
+	c.Write("CAP LS 302")
+	c.Write("USER %s %s * :%s", nick, host, c.Realname)
+	c.Write("NICK %s", nick)
 }
 
-func (c *Client) Connected(channel string) bool {
-	for _, c := range c.Channels {
-		if c == channel {
-			return true
-		}
-	}
+// Reconnect replaces the connection of the client with the given one,
+// e.g. after the previous connection was lost. Setup must be called
+// afterwards to register on the new connection.
+func (c *Client) Reconnect(conn net.Conn) {
+	c.connMtx.Lock()
+	c.conn = conn
+	c.connMtx.Unlock()
+
+	c.queue.clear()
+	c.failQueries(ErrDisconnected)
+	c.state.reset()
+
+	c.prefixMtx.Lock()
+	c.user, c.host = "", ""
+	c.prefixMtx.Unlock()
 
-	return false
+	c.moreMtx.Lock()
+	c.more = make(map[string]*buffered)
+	c.moreMtx.Unlock()
+
+	c.pingMtx.Lock()
+	c.ping.token = ""
+	c.pingMtx.Unlock()
 }
 
-func (c *Client) Write(format string, argv ...interface{}) error {
-	_, err := fmt.Fprintf(c.conn, "%s\r\n", sanitize(fmt.Sprintf(format, argv...)))
-	if err != nil {
+// SetLimits changes the flood control limits of the client.
+func (c *Client) SetLimits(limits Limits) {
+	c.queue.setLimits(limits)
+}
+
+// Context returns a context which is canceled when Quit is called.
+// Goroutines started by modules should terminate once it is done.
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
+// Quit cancels the context of the client and sends a QUIT with the
+// given message after all lines queued so far. Afterwards, the
+// connection is closed. If the queue couldn't be drained within the
+// given timeout the connection is closed nonetheless.
+func (c *Client) Quit(msg string, timeout time.Duration) error {
+	c.cancel()
+	if err := c.WritePriority(PriorityLow, "QUIT :%s", msg); err != nil {
 		return err
 	}
 
-	return nil
+	c.queue.drain(timeout)
+	return c.disconnect()
 }
 
-func (c *Client) Handle(data string, ch chan error) {
-	msg := parseMessage(data)
-	hooks, ok := c.hooks[msg.Command]
-	if ok {
-		for _, hook := range hooks {
-			go func(h Hook) {
-				if err := h(c, msg); err != nil {
-					ch <- err
-				}
-			}(hook)
-		}
+// disconnect closes the current connection of the client.
+func (c *Client) disconnect() error {
+	c.connMtx.Lock()
+	defer c.connMtx.Unlock()
+
+	if c.conn == nil {
+		return nil
 	}
+
+	return c.conn.Close()
 }
 
-func (c *Client) CmdHook(cmd string, hook Hook) {
-	c.hooks[cmd] = append(c.hooks[cmd], hook)
+// Connected reports whether the client is in the given channel.
+func (c *Client) Connected(channel string) bool {
+	_, ok := c.Channel(channel)
+	return ok
 }
 
-func joinCmd(client *Client, msg Message) error {
-	if msg.Sender.Name == client.Nickname {
-		client.Channels = append(client.Channels, msg.Data)
+// Write queues the given line for sending. Lines containing commands
+// needed for keeping the connection alive or for authentication are
+// sent before any other lines. Write is safe for concurrent use.
+func (c *Client) Write(format string, argv ...interface{}) error {
+	line := sanitize(fmt.Sprintf(format, argv...))
+
+	prio := PriorityNormal
+	if cmd, _ := cut(line); highPriority[strings.ToUpper(cmd)] {
+		prio = PriorityHigh
 	}
 
-	return nil
+	return c.queue.push(prio, line)
+}
+
+// WritePriority is like Write but queues the line with the given
+// priority, e.g. PriorityLow for broadcasts.
+func (c *Client) WritePriority(prio Priority, format string, argv ...interface{}) error {
+	return c.queue.push(prio, sanitize(fmt.Sprintf(format, argv...)))
+}
+
+// writer sends queued lines to the server. If writing fails the
+// connection is closed which causes the next read to fail as well.
+func (c *Client) writer() {
+	for {
+		line := c.queue.pop()
+
+		data := c.encode(line)
+
+		c.connMtx.Lock()
+		conn := c.conn
+		c.connMtx.Unlock()
+
+		if conn == nil {
+			c.queue.done()
+			continue
+		}
+
+		// The lock isn't held while writing, thus disconnect can
+		// always interrupt a write blocked by a stalled peer.
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		_, err := fmt.Fprintf(conn, "%s\r\n", data)
+
+		c.queue.done()
+		if err != nil {
+			conn.Close()
+		} else {
+			c.record(Outbound, line)
+		}
+	}
 }
 
-func partCmd(client *Client, msg Message) error {
-	if msg.Sender.Name == client.Nickname {
-		client.Channels = remove(msg.Data, client.Channels)
+func (c *Client) Handle(data string, ch chan error) {
+	c.record(Inbound, data)
+
+	msg := parseMessage(c.decode(data))
+	for _, handler := range c.handlers[msg.Command] {
+		if err := callHook(funcName(handler), handler, c, msg); err != nil {
+			ch <- err
+		}
 	}
 
-	return nil
+	c.trackAccount(msg)
+	msg.Account = c.messageAccount(msg)
+
+	c.collect(msg)
+	c.runHooks(msg, ch)
 }
 
-func kickCmd(client *Client, msg Message) error {
-	if msg.Data == client.Nickname {
-		target := strings.Fields(msg.Receiver)[0]
-		client.Channels = remove(target, client.Channels)
+// Wait blocks until all hooks started by Handle so far returned.
+func (c *Client) Wait() {
+	c.running.Wait()
+}
+
+// sleep pauses the current goroutine for the given duration. It returns
+// false if the context of the client was canceled in the meantime.
+func (c *Client) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-c.ctx.Done():
+		return false
 	}
+}
 
-	return nil
+// protoHook registers a hook which is responsible for handling the
+// IRC protocol itself. These hooks are run synchronously and in order
+// of registration before any hook registered using AddHook is run.
+func (c *Client) protoHook(cmd string, hook Hook) {
+	c.handlers[cmd] = append(c.handlers[cmd], hook)
 }
 
 func pingCmd(client *Client, msg Message) error {
 	return client.Write("PONG %s", msg.Data)
 }
 
-// sanitize removes all non-printable characters from
-// the given string by returning a new string without them.
+// sanitize removes all non-printable characters except for
+// formatting codes from the given string by returning a new
+// string without them.
 func sanitize(text string) string {
 	mfunc := func(r rune) rune {
 		switch {
+		case format.IsCode(r):
+			return r
 		case !unicode.IsPrint(r):
 			return ' '
 		case unicode.IsSpace(r):
@@ -135,16 +400,3 @@ func sanitize(text string) string {
 	escaped := strings.Map(mfunc, text)
 	return strings.Join(strings.Fields(escaped), " ")
 }
-
-// remove deletes a given element from a given slice. A new slice
-// which does not contain the given element is returned.
-func remove(element string, slice []string) []string {
-	var newSlice []string
-	for _, e := range slice {
-		if e != element {
-			newSlice = append(newSlice, e)
-		}
-	}
-
-	return newSlice
-}
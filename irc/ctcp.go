@@ -0,0 +1,162 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Delimiter of CTCP messages.
+const ctcpDelim = "\x01"
+
+// CTCP requests answered by the client by default.
+var defaultCTCPReplies = []string{"VERSION", "PING", "TIME", "CLIENTINFO"}
+
+// CTCP represents a CTCP message embedded in a PRIVMSG or NOTICE.
+type CTCP struct {
+	// Uppercase CTCP command, e.g. VERSION.
+	Command string
+
+	// Parameters of the command, may be empty.
+	Params string
+
+	// Whether the message is a reply, i.e. was sent as NOTICE.
+	Reply bool
+}
+
+type CTCPHook func(*Client, Message, CTCP) error
+
+// CTCP parses the CTCP message contained in the message. The second
+// return value is false if the message doesn't contain one.
+func (m Message) CTCP() (CTCP, bool) {
+	if m.Command != "privmsg" && m.Command != "notice" {
+		return CTCP{}, false
+	}
+
+	data := m.Param(len(m.Params) - 1)
+	if !strings.HasPrefix(data, ctcpDelim) {
+		return CTCP{}, false
+	}
+
+	data = strings.TrimPrefix(data, ctcpDelim)
+	data = strings.TrimSuffix(data, ctcpDelim)
+	if len(data) <= 0 {
+		return CTCP{}, false
+	}
+
+	cmd, params := cut(data)
+	return CTCP{strings.ToUpper(cmd), params, m.Command == "notice"}, true
+}
+
+// CTCPHook registers a hook which is run for CTCP requests and replies
+// with the given command, e.g. ACTION.
+func (c *Client) CTCPHook(cmd string, hook CTCPHook) {
+	cmd = strings.ToUpper(cmd)
+
+	c.hookMtx.Lock()
+	defer c.hookMtx.Unlock()
+
+	c.ctcpHooks[cmd] = append(c.ctcpHooks[cmd], hook)
+}
+
+// CTCP sends a CTCP request with the given command and parameters to
+// the given target.
+func (c *Client) CTCP(target, cmd, params string) error {
+	return c.writeText(PriorityNormal, "PRIVMSG", cmd, target, params)
+}
+
+// CTCPReply sends a CTCP reply with the given command and parameters
+// to the given target.
+func (c *Client) CTCPReply(target, cmd, params string) error {
+	return c.writeText(PriorityLow, "NOTICE", cmd, target, params)
+}
+
+// ctcpCommands returns all CTCP commands understood by the client.
+func (c *Client) ctcpCommands() []string {
+	cmds := []string{"ACTION"}
+	cmds = append(cmds, c.CTCPReplies...)
+
+	c.hookMtx.RLock()
+	defer c.hookMtx.RUnlock()
+
+	for cmd := range c.ctcpHooks {
+		if !contains(cmds, cmd) {
+			cmds = append(cmds, cmd)
+		}
+	}
+
+	sort.Strings(cmds)
+	return cmds
+}
+
+// ctcpReply returns the reply to the given CTCP request if it is
+// answered by the client itself.
+func (c *Client) ctcpReply(ctcp CTCP) (string, bool) {
+	if ctcp.Reply || !contains(c.CTCPReplies, ctcp.Command) {
+		return "", false
+	}
+
+	switch ctcp.Command {
+	case "VERSION":
+		return c.Version, len(c.Version) > 0
+	case "PING":
+		return ctcp.Params, true
+	case "TIME":
+		return time.Now().Format(time.RFC1123Z), true
+	case "CLIENTINFO":
+		return strings.Join(c.ctcpCommands(), " "), true
+	}
+
+	return "", false
+}
+
+// ctcpCmd answers CTCP requests and passes CTCP messages to the hooks
+// registered using CTCPHook.
+func ctcpCmd(client *Client, msg Message) error {
+	ctcp, ok := msg.CTCP()
+	if !ok || len(msg.Sender.User) <= 0 || client.EqualFold(msg.Sender.Name, client.Nick()) {
+		return nil
+	}
+
+	if reply, ok := client.ctcpReply(ctcp); ok {
+		err := client.CTCPReply(msg.Sender.Name, ctcp.Command, reply)
+		if err != nil {
+			return err
+		}
+	}
+
+	client.hookMtx.RLock()
+	hooks := client.ctcpHooks[ctcp.Command]
+	client.hookMtx.RUnlock()
+
+	for _, hook := range hooks {
+		if err := hook(client, msg, ctcp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func contains(slice []string, element string) bool {
+	for _, e := range slice {
+		if e == element {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,186 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package format implements the mIRC formatting codes understood by
+// most IRC clients.
+package format
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Formatting control codes.
+const (
+	BoldCode          = '\x02'
+	ColorCode         = '\x03'
+	HexColorCode      = '\x04'
+	ResetCode         = '\x0f'
+	MonospaceCode     = '\x11'
+	ReverseCode       = '\x16'
+	ItalicCode        = '\x1d'
+	StrikethroughCode = '\x1e'
+	UnderlineCode     = '\x1f'
+)
+
+// Reset removes all formatting applied so far.
+const Reset = string(ResetCode)
+
+// Color is one of the 16 standard mIRC colors.
+type Color int
+
+const (
+	White Color = iota
+	Black
+	Blue
+	Green
+	Red
+	Brown
+	Magenta
+	Orange
+	Yellow
+	LightGreen
+	Cyan
+	LightCyan
+	LightBlue
+	Pink
+	Grey
+	LightGrey
+)
+
+func wrap(code rune, text string) string {
+	return string(code) + text + string(code)
+}
+
+// Bold returns the given text formatted in bold.
+func Bold(text string) string {
+	return wrap(BoldCode, text)
+}
+
+// Italic returns the given text formatted in italics.
+func Italic(text string) string {
+	return wrap(ItalicCode, text)
+}
+
+// Underline returns the given text underlined.
+func Underline(text string) string {
+	return wrap(UnderlineCode, text)
+}
+
+// Strikethrough returns the given text struck through.
+func Strikethrough(text string) string {
+	return wrap(StrikethroughCode, text)
+}
+
+// Monospace returns the given text formatted in a monospace font.
+func Monospace(text string) string {
+	return wrap(MonospaceCode, text)
+}
+
+// Colorize returns the given text using the given foreground color.
+func Colorize(text string, fg Color) string {
+	// The color is always sent with two digits, otherwise text
+	// starting with a digit would be parsed as part of the color.
+	return fmt.Sprintf("%c%02d%s%c", ColorCode, fg, text, ColorCode)
+}
+
+// ColorizeBg returns the given text using the given foreground and
+// background colors.
+func ColorizeBg(text string, fg, bg Color) string {
+	return fmt.Sprintf("%c%02d,%02d%s%c", ColorCode, fg, bg, text, ColorCode)
+}
+
+// IsCode reports whether the given rune is a formatting code.
+func IsCode(r rune) bool {
+	switch r {
+	case BoldCode, ColorCode, HexColorCode, ResetCode, MonospaceCode,
+		ReverseCode, ItalicCode, StrikethroughCode, UnderlineCode:
+		return true
+	default:
+		return false
+	}
+}
+
+// Strip removes all formatting codes, including color parameters,
+// from the given text.
+func Strip(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == ColorCode:
+			i += colorLen(text[i+1:], 1, 2, isDigit)
+		case c == HexColorCode:
+			i += colorLen(text[i+1:], 6, 6, isHexDigit)
+		case IsCode(rune(c)):
+			continue
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// Style can be embedded into the configuration of a module to allow
+// disabling the formatting of its output using the "plain" option.
+type Style struct {
+	// Whether formatting is removed from the output.
+	Plain bool `json:"plain"`
+}
+
+// Apply returns the given text without formatting if Plain is set.
+func (s Style) Apply(text string) string {
+	if s.Plain {
+		return Strip(text)
+	}
+
+	return text
+}
+
+// colorLen returns the length of the foreground and optional background
+// color at the beginning of the given text. Each color consists of min
+// to max characters matching the given function.
+func colorLen(text string, min, max int, fn func(byte) bool) int {
+	n := digits(text, min, max, fn)
+	if n <= 0 || n >= len(text) || text[n] != ',' {
+		return n
+	}
+
+	if bg := digits(text[n+1:], min, max, fn); bg > 0 {
+		return n + 1 + bg
+	}
+
+	return n
+}
+
+func digits(text string, min, max int, fn func(byte) bool) int {
+	n := 0
+	for n < len(text) && n < max && fn(text[n]) {
+		n++
+	}
+
+	if n < min {
+		return 0
+	}
+
+	return n
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,52 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package format
+
+import (
+	"testing"
+)
+
+func TestStrip(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"plain", "plain"},
+		{"\x02bold\x02 \x1ditalic\x1d \x1funderline\x1f", "bold italic underline"},
+		{"\x0304red\x03", "red"},
+		{"\x034,12red on blue\x03", "red on blue"},
+		{"\x0304,text", ",text"},
+		{"\x03123", "3"},
+		{"\x04ff0000red\x04", "red"},
+		{"\x04FF0000,00ff00red", "red"},
+		{"\x16reverse\x0f reset \x11mono\x1e", "reverse reset mono"},
+		{"trailing\x03", "trailing"},
+	}
+
+	for _, test := range tests {
+		if text := Strip(test.text); text != test.want {
+			t.Errorf("Strip(%q) = %q, want %q", test.text, text, test.want)
+		}
+	}
+}
+
+func TestStyle(t *testing.T) {
+	text := Bold("bold") + " " + Colorize("red", Red)
+	if styled := (Style{}).Apply(text); styled != text {
+		t.Errorf("Apply(%q) = %q, want unchanged text", text, styled)
+	}
+	if plain := (Style{Plain: true}).Apply(text); plain != "bold red" {
+		t.Errorf("Apply(%q) = %q, want %q", text, plain, "bold red")
+	}
+}
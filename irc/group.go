@@ -0,0 +1,84 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"sort"
+	"sync"
+)
+
+// Group is a set of clients connected to different networks, allowing
+// each of them to send messages to the other networks.
+type Group struct {
+	mtx     sync.RWMutex
+	clients map[string]*Client
+}
+
+func NewGroup() *Group {
+	return &Group{clients: make(map[string]*Client)}
+}
+
+// Add adds the given client to the group using the given network name.
+// A client previously added with the same name is replaced.
+func (g *Group) Add(network string, client *Client) {
+	g.mtx.Lock()
+	g.clients[network] = client
+	g.mtx.Unlock()
+
+	client.groupMtx.Lock()
+	client.group, client.network = g, network
+	client.groupMtx.Unlock()
+}
+
+// Client returns the client for the network with the given name.
+func (g *Group) Client(network string) (*Client, bool) {
+	g.mtx.RLock()
+	defer g.mtx.RUnlock()
+
+	client, ok := g.clients[network]
+	return client, ok
+}
+
+// Networks returns the names of all networks in the group.
+func (g *Group) Networks() []string {
+	g.mtx.RLock()
+	defer g.mtx.RUnlock()
+
+	var names []string
+	for name := range g.clients {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Group returns the group the client was added to, nil if none.
+func (c *Client) Group() *Group {
+	c.groupMtx.Lock()
+	defer c.groupMtx.Unlock()
+
+	return c.group
+}
+
+// Peer returns the client for the network with the given name from
+// the group the client was added to.
+func (c *Client) Peer(network string) (*Client, bool) {
+	group := c.Group()
+	if group == nil {
+		return nil, false
+	}
+
+	return group.Client(network)
+}
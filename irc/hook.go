@@ -0,0 +1,158 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+)
+
+// ErrConsumed can be returned by a synchronous hook to prevent all
+// hooks with a lower priority from being run for the message.
+var ErrConsumed = errors.New("message consumed")
+
+// Priority of hooks registered using CmdHook.
+const DefaultHookPriority = 0
+
+// HookOptions describes how a hook registered using AddHook is run.
+type HookOptions struct {
+	// Hooks with a higher priority are run first, hooks with an
+	// equal priority in order of registration.
+	Priority int
+
+	// Whether the hook is run synchronously by Handle. Otherwise it
+	// is run in its own goroutine and can't consume the message.
+	Sync bool
+
+	// Name used when reporting a panic, defaults to the name of the
+	// hook function.
+	Name string
+}
+
+type hookEntry struct {
+	hook Hook
+	opts HookOptions
+}
+
+// HookHandle refers to a hook registered using AddHook or CmdHook.
+type HookHandle struct {
+	client *Client
+	cmd    string
+	entry  *hookEntry
+}
+
+// AddHook registers a hook which is run for messages with the given
+// command using the given options. It is safe to call AddHook while
+// messages are being handled.
+func (c *Client) AddHook(cmd string, hook Hook, opts HookOptions) *HookHandle {
+	if len(opts.Name) <= 0 {
+		opts.Name = funcName(hook)
+	}
+
+	entry := &hookEntry{hook, opts}
+
+	c.hookMtx.Lock()
+	defer c.hookMtx.Unlock()
+
+	// Copy the slice since Handle might still be using the old one.
+	hooks := append(append([]*hookEntry(nil), c.hooks[cmd]...), entry)
+	sort.SliceStable(hooks, func(i, j int) bool {
+		return hooks[i].opts.Priority > hooks[j].opts.Priority
+	})
+	c.hooks[cmd] = hooks
+
+	return &HookHandle{c, cmd, entry}
+}
+
+// CmdHook registers a hook which is run asynchronously with the default
+// priority for messages with the given command.
+func (c *Client) CmdHook(cmd string, hook Hook) *HookHandle {
+	return c.AddHook(cmd, hook, HookOptions{Priority: DefaultHookPriority})
+}
+
+// Remove unregisters the hook. Messages already being handled might
+// still be passed to it.
+func (h *HookHandle) Remove() {
+	c := h.client
+	c.hookMtx.Lock()
+	defer c.hookMtx.Unlock()
+
+	var hooks []*hookEntry
+	for _, entry := range c.hooks[h.cmd] {
+		if entry != h.entry {
+			hooks = append(hooks, entry)
+		}
+	}
+
+	if len(hooks) > 0 {
+		c.hooks[h.cmd] = hooks
+	} else {
+		delete(c.hooks, h.cmd)
+	}
+}
+
+// runHooks runs the hooks registered for the command of the given
+// message in order of their priority until one of the synchronous
+// hooks consumes the message.
+func (c *Client) runHooks(msg Message, ch chan error) {
+	c.hookMtx.RLock()
+	hooks := c.hooks[msg.Command]
+	c.hookMtx.RUnlock()
+
+	for _, entry := range hooks {
+		if entry.opts.Sync {
+			err := callHook(entry.opts.Name, entry.hook, c, msg)
+			if err == ErrConsumed {
+				return
+			} else if err != nil {
+				ch <- err
+			}
+			continue
+		}
+
+		c.running.Add(1)
+		go func(e *hookEntry) {
+			defer c.running.Done()
+			err := callHook(e.opts.Name, e.hook, c, msg)
+			if err != nil && err != ErrConsumed {
+				ch <- err
+			}
+		}(entry)
+	}
+}
+
+// callHook runs the given hook and converts a panic into an error
+// mentioning the name of the hook.
+func callHook(name string, hook Hook, client *Client, msg Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("hook %s for %s panicked: %v", name, msg.Command, r)
+		}
+	}()
+
+	return hook(client, msg)
+}
+
+// funcName returns the name of the given function.
+func funcName(fn interface{}) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+
+	return f.Name()
+}
@@ -0,0 +1,108 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irctest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCapNegotiation(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.Client.Setup("marvin", "irctest", "irctest")
+	for _, want := range []string{"CAP LS 302", "USER ", "NICK marvin"} {
+		if _, err := s.Skip(want); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Multi-line replies are only answered after the last line.
+	s.Send(":irctest CAP * LS * :multi-prefix unknown")
+	s.Send(":irctest CAP * LS :server-time cap-notify")
+	if err := s.Expect("CAP REQ :server-time multi-prefix cap-notify"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Send(":irctest CAP * ACK :server-time multi-prefix cap-notify")
+	if err := s.Expect("CAP END"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"cap-notify", "multi-prefix", "server-time"}
+	if caps := s.Client.Caps(); !reflect.DeepEqual(caps, want) {
+		t.Fatalf("Caps() = %v, want %v", caps, want)
+	}
+}
+
+func TestCapRefused(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.Client.Setup("marvin", "irctest", "irctest")
+	if _, err := s.Skip("NICK "); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Send(":irctest CAP * LS :multi-prefix")
+	if err := s.Expect("CAP REQ :multi-prefix"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Send(":irctest CAP * NAK :multi-prefix")
+	if err := s.Expect("CAP END"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Client.HasCap("multi-prefix") {
+		t.Fatal("refused capability enabled")
+	}
+}
+
+func TestCapNotify(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.Caps = []string{"cap-notify"}
+	if err := s.Register("marvin"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Send(":irctest CAP marvin NEW :away-notify unknown")
+	if err := s.Expect("CAP REQ :away-notify"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Send(":irctest CAP marvin ACK :away-notify")
+	if err := s.Client.RequestCap("draft/example"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Quiet(50 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Send(":irctest CAP marvin NEW :draft/example")
+	if err := s.Expect("CAP REQ :draft/example"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Send(":irctest CAP marvin DEL :away-notify")
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Client.HasCap("away-notify") {
+		t.Fatal("deleted capability still enabled")
+	}
+}
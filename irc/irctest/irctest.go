@@ -0,0 +1,315 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package irctest implements a scripted IRC server for testing modules
+// without connecting to a real network.
+//
+// A typical test registers the client, loads a module, injects lines
+// as arbitrary users and asserts on the lines sent by the client:
+//
+//	s := irctest.NewServer()
+//	defer s.Close()
+//
+//	if err := s.Register("marvin"); err != nil {
+//		t.Fatal(err)
+//	}
+//
+//	module := new(time.Module)
+//	module.Defaults()
+//	if err := s.Load(module); err != nil {
+//		t.Fatal(err)
+//	}
+//
+//	s.Privmsg("alice", "marvin", "!time")
+//	if _, err := s.ExpectMatch(`^NOTICE alice :`); err != nil {
+//		t.Fatal(err)
+//	}
+package irctest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/nmeum/marvin/irc"
+	"github.com/nmeum/marvin/modules"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Default time to wait for a line sent by the client.
+const DefaultTimeout = 1 * time.Second
+
+// Server is a fake IRC server connected to a single client using an
+// in-memory connection.
+type Server struct {
+	conn   net.Conn
+	lines  chan string
+	errors chan error
+
+	// Client connected to the server and the modules loaded by Load.
+	Client  *irc.Client
+	Modules *modules.ModuleSet
+
+	// Nickname of the client, set by Register.
+	Nick string
+
+	// Capabilities offered during registration.
+	Caps []string
+
+	// Parameters sent using RPL_ISUPPORT during registration.
+	ISupport []string
+
+	// Time to wait for a line sent by the client.
+	Timeout time.Duration
+}
+
+// NewServer creates a new server and a client connected to it. The
+// flood control of the client is disabled.
+func NewServer() *Server {
+	client, server := net.Pipe()
+	s := &Server{
+		conn:    server,
+		lines:   make(chan string, 1024),
+		errors:  make(chan error, 1024),
+		Client:  irc.NewClient(client),
+		Timeout: DefaultTimeout,
+
+		ISupport: []string{"CASEMAPPING=rfc1459", "CHANTYPES=#&",
+			"PREFIX=(ov)@+", "CHANMODES=beI,k,l,imnpst", "NETWORK=irctest"},
+	}
+	s.Client.SetLimits(irc.Limits{})
+	s.Modules = modules.NewModuleSet(s.Client, "")
+
+	go s.read()
+	go s.handle(client)
+
+	return s
+}
+
+// read collects the lines sent by the client.
+func (s *Server) read() {
+	defer close(s.lines)
+
+	scanner := bufio.NewScanner(s.conn)
+	for scanner.Scan() {
+		s.lines <- strings.TrimSuffix(scanner.Text(), "\r")
+	}
+}
+
+// handle passes the lines sent by the server to the client.
+func (s *Server) handle(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		s.Client.Handle(strings.TrimSuffix(scanner.Text(), "\r"), s.errors)
+	}
+}
+
+// Close closes the connection and cancels the context of the client.
+func (s *Server) Close() error {
+	s.Client.Quit("irctest", 0)
+	return s.conn.Close()
+}
+
+// Err returns the first error returned by a hook of the client which
+// wasn't returned yet, nil if there is none.
+func (s *Server) Err() error {
+	select {
+	case err := <-s.errors:
+		return err
+	default:
+		return nil
+	}
+}
+
+// Send sends the given line to the client.
+func (s *Server) Send(format string, argv ...interface{}) error {
+	_, err := fmt.Fprintf(s.conn, format+"\r\n", argv...)
+	return err
+}
+
+// SendAt is like Send but adds a server-time tag with the given time.
+func (s *Server) SendAt(t time.Time, format string, argv ...interface{}) error {
+	tag := t.UTC().Format("2006-01-02T15:04:05.000Z")
+	return s.Send("@time=%s %s", tag, fmt.Sprintf(format, argv...))
+}
+
+// Prefix returns the prefix used for messages sent by the given nick.
+func Prefix(nick string) string {
+	return fmt.Sprintf("%s!%s@irctest", nick, strings.ToLower(nick))
+}
+
+// Privmsg sends a PRIVMSG from the given nick to the given target.
+func (s *Server) Privmsg(nick, target, text string) error {
+	return s.Send(":%s PRIVMSG %s :%s", Prefix(nick), target, text)
+}
+
+// Notice sends a NOTICE from the given nick to the given target.
+func (s *Server) Notice(nick, target, text string) error {
+	return s.Send(":%s NOTICE %s :%s", Prefix(nick), target, text)
+}
+
+// Next returns the next line sent by the client. An error is returned
+// if no line was sent within the timeout.
+func (s *Server) Next() (string, error) {
+	select {
+	case line, ok := <-s.lines:
+		if !ok {
+			return "", errors.New("connection closed")
+		}
+		return line, nil
+	case <-time.After(s.Timeout):
+		return "", errors.New("timeout waiting for line")
+	}
+}
+
+// Expect returns an error if the next line sent by the client isn't
+// the given one.
+func (s *Server) Expect(want string) error {
+	line, err := s.Next()
+	if err != nil {
+		return fmt.Errorf("expected %q: %s", want, err)
+	} else if line != want {
+		return fmt.Errorf("expected %q, got %q", want, line)
+	}
+
+	return nil
+}
+
+// ExpectMatch returns the next line sent by the client or an error if
+// it doesn't match the given regular expression.
+func (s *Server) ExpectMatch(expr string) (string, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return "", err
+	}
+
+	line, err := s.Next()
+	if err != nil {
+		return "", fmt.Errorf("expected %q: %s", expr, err)
+	} else if !re.MatchString(line) {
+		return line, fmt.Errorf("expected %q, got %q", expr, line)
+	}
+
+	return line, nil
+}
+
+// Skip discards lines sent by the client until one starts with the
+// given prefix and returns it.
+func (s *Server) Skip(prefix string) (string, error) {
+	for {
+		line, err := s.Next()
+		if err != nil {
+			return "", fmt.Errorf("expected %q: %s", prefix, err)
+		} else if strings.HasPrefix(line, prefix) {
+			return line, nil
+		}
+	}
+}
+
+// Quiet returns an error if the client sends a line within the given
+// duration.
+func (s *Server) Quiet(d time.Duration) error {
+	select {
+	case line := <-s.lines:
+		return fmt.Errorf("unexpected line %q", line)
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// Drain discards all lines sent by the client so far.
+func (s *Server) Drain() {
+	for {
+		select {
+		case <-s.lines:
+		default:
+			return
+		}
+	}
+}
+
+// Register drives the client through the registration using the given
+// nickname, including the capability negotiation. SASL isn't supported,
+// tests for it have to drive the registration themselves.
+func (s *Server) Register(nick string) error {
+	s.Client.Setup(nick, "irctest", "irctest")
+	for _, want := range []string{"CAP LS 302", "USER ", "NICK " + nick} {
+		if _, err := s.Skip(want); err != nil {
+			return err
+		}
+	}
+
+	// All requested capabilities are acknowledged.
+	s.Send(":irctest CAP * LS :%s", strings.Join(s.Caps, " "))
+	for {
+		line, err := s.Skip("CAP ")
+		if err != nil {
+			return err
+		} else if line == "CAP END" {
+			break
+		}
+
+		if strings.HasPrefix(line, "CAP REQ ") {
+			caps := strings.TrimPrefix(strings.TrimPrefix(line, "CAP REQ "), ":")
+			s.Send(":irctest CAP * ACK :%s", caps)
+		}
+	}
+
+	s.Nick = nick
+	s.Send(":irctest 001 %s :Welcome to irctest %s", nick, Prefix(nick))
+	s.Send(":irctest 005 %s %s :are supported by this server",
+		nick, strings.Join(s.ISupport, " "))
+	s.Send(":irctest 376 %s :End of /MOTD command.", nick)
+
+	return s.sync()
+}
+
+// sync waits until the client handled all lines sent so far.
+func (s *Server) sync() error {
+	token := fmt.Sprintf("irctest-%d", time.Now().UnixNano())
+	s.Send("PING :%s", token)
+
+	_, err := s.Skip("PONG " + token)
+	return err
+}
+
+// Join makes the client join the given channel with the given other
+// members. Members may be prefixed with membership prefixes, e.g. "@".
+func (s *Server) Join(channel string, members ...string) error {
+	s.Send(":%s JOIN %s", Prefix(s.Nick), channel)
+	s.Send(":irctest 353 %s = %s :%s", s.Nick, channel,
+		strings.Join(append(members, s.Nick), " "))
+	s.Send(":irctest 366 %s %s :End of /NAMES list.", s.Nick, channel)
+
+	return s.sync()
+}
+
+// UserJoin makes the given nick join the given channel.
+func (s *Server) UserJoin(nick, channel string) error {
+	s.Send(":%s JOIN %s", Prefix(nick), channel)
+	return s.sync()
+}
+
+// UserPart makes the given nick leave the given channel.
+func (s *Server) UserPart(nick, channel string) error {
+	s.Send(":%s PART %s", Prefix(nick), channel)
+	return s.sync()
+}
+
+// Load loads the given module using Modules. Defaults isn't called,
+// the module should be configured beforehand.
+func (s *Server) Load(module modules.Module) error {
+	return s.Modules.Load(module)
+}
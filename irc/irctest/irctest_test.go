@@ -0,0 +1,104 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irctest
+
+import (
+	"github.com/nmeum/marvin/modules/time"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.Caps = []string{"multi-prefix", "server-time"}
+	if err := s.Register("marvin"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.Client.Registered() {
+		t.Fatal("client isn't registered")
+	}
+	if !s.Client.HasCap("multi-prefix") || !s.Client.HasCap("server-time") {
+		t.Fatalf("capabilities not enabled: %v", s.Client.Caps())
+	}
+	if network := s.Client.Network(); network != "irctest" {
+		t.Fatalf("network = %q, want %q", network, "irctest")
+	}
+}
+
+func TestModule(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	if err := s.Register("marvin"); err != nil {
+		t.Fatal(err)
+	}
+
+	module := new(time.Module)
+	module.Defaults()
+	if err := s.Load(module); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Privmsg("alice", "marvin", "!time")
+	if line, err := s.ExpectMatch(`^NOTICE alice :`); err != nil {
+		t.Fatal(line, err)
+	}
+
+	if err := s.Join("#chan", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Privmsg("alice", "#chan", "!time")
+	if line, err := s.ExpectMatch(`^NOTICE #chan :`); err != nil {
+		t.Fatal(line, err)
+	}
+}
+
+func TestChannelState(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	if err := s.Register("marvin"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Join("#Chan", "@alice", "+bob"); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Client.IsOp("#chan", "Alice") || s.Client.IsOp("#chan", "bob") {
+		t.Fatal("wrong operator status")
+	}
+
+	if err := s.UserJoin("carol", "#chan"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UserPart("bob", "#chan"); err != nil {
+		t.Fatal(err)
+	}
+
+	ch, ok := s.Client.Channel("#CHAN")
+	if !ok {
+		t.Fatal("channel not tracked")
+	}
+
+	members := make(map[string]bool)
+	for _, member := range ch.Members {
+		members[member.Nick] = true
+	}
+	if len(members) != 3 || !members["alice"] || !members["carol"] || !members["marvin"] {
+		t.Fatalf("members = %v", ch.Members)
+	}
+}
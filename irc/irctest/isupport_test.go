@@ -0,0 +1,54 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irctest
+
+import (
+	"testing"
+)
+
+func TestISupportRemoved(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.ISupport = []string{"CASEMAPPING=ascii", "CHANTYPES=#!", "PREFIX=(qov)~@+"}
+	if err := s.Register("marvin"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Join("#chan", "~alice"); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Client.IsChannel("!chan") || s.Client.Fold("Nick[]") != "nick[]" {
+		t.Fatal("advertised parameters not applied")
+	}
+
+	// Removed parameters fall back to the defaults.
+	s.Send(":irctest 005 marvin -CASEMAPPING -CHANTYPES -PREFIX :are supported by this server")
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.Client.ISupport("CASEMAPPING"); ok {
+		t.Fatal("removed parameter still advertised")
+	}
+	if s.Client.IsChannel("!chan") || !s.Client.IsChannel("&chan") {
+		t.Fatal("channel types not reset")
+	}
+	if fold := s.Client.Fold("Nick[]"); fold != "nick{}" {
+		t.Fatalf("Fold() = %q, want %q", fold, "nick{}")
+	}
+	if _, ok := s.Client.Channel("#CHAN"); !ok {
+		t.Fatal("channel not refolded")
+	}
+}
@@ -0,0 +1,199 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irctest
+
+import (
+	"context"
+	"errors"
+	"github.com/nmeum/marvin/irc"
+	"testing"
+	"time"
+)
+
+// Time to wait for the results of queries.
+const queryTimeout = 2 * time.Second
+
+func TestWhoMask(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	if err := s.Register("marvin"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	replies := make(chan []irc.WhoReply)
+	go func() {
+		who, err := s.Client.Who(ctx, "#Chan")
+		if err != nil {
+			t.Error(err)
+		}
+		replies <- who
+	}()
+
+	if err := s.Expect("WHO #Chan"); err != nil {
+		t.Fatal(err)
+	}
+
+	// End of a WHO for another mask, e.g. sent using Write.
+	s.Send(":irctest 315 marvin #other :End of /WHO list.")
+	s.Send(":irctest 352 marvin #chan ~alice irctest irctest alice H@ :0 Alice")
+	s.Send(":irctest 315 marvin #chan :End of /WHO list.")
+
+	who := <-replies
+	if len(who) != 1 || who[0].Nick != "alice" || who[0].Prefixes != "@" {
+		t.Fatalf("replies = %+v", who)
+	}
+}
+
+// whoisResult is the result of a WHOIS query made by whois.
+type whoisResult struct {
+	info irc.WhoisInfo
+	err  error
+}
+
+// whois queries the given nick in the background.
+func whois(ctx context.Context, s *Server, nick string) chan whoisResult {
+	result := make(chan whoisResult, 1)
+	go func() {
+		info, err := s.Client.Whois(ctx, nick)
+		result <- whoisResult{info, err}
+	}()
+
+	return result
+}
+
+func TestQueryOrder(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	if err := s.Register("marvin"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	alice := whois(ctx, s, "alice")
+	if err := s.Expect("WHOIS alice"); err != nil {
+		t.Fatal(err)
+	}
+	bob := whois(ctx, s, "bob")
+	if err := s.Expect("WHOIS bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replies without a label are matched using the nick.
+	s.Send(":irctest 401 marvin bob :No such nick")
+	s.Send(":irctest 311 marvin alice ~alice example.org * :Alice")
+	s.Send(":irctest 330 marvin alice alice_account :is logged in as")
+	s.Send(":irctest 318 marvin alice :End of /WHOIS list.")
+	s.Send(":irctest 318 marvin bob :End of /WHOIS list.")
+
+	result := <-alice
+	if result.err != nil {
+		t.Fatal(result.err)
+	} else if result.info.Host != "example.org" || result.info.Account != "alice_account" {
+		t.Fatalf("info = %+v", result.info)
+	}
+
+	var numeric *irc.NumericError
+	if result := <-bob; !errors.As(result.err, &numeric) || numeric.Code != "401" {
+		t.Fatalf("err = %v, want ERR_NOSUCHNICK", result.err)
+	}
+}
+
+func TestQueryExpired(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	if err := s.Register("marvin"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	expired := whois(ctx, s, "alice")
+	if err := s.Expect("WHOIS alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	if result := <-expired; result.err != context.Canceled {
+		t.Fatalf("err = %v, want %v", result.err, context.Canceled)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	// Generic errors don't mention the target, the expired query
+	// mustn't take the error of the pending one.
+	pending := whois(ctx, s, "alice")
+	if err := s.Expect("WHOIS alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Send(":irctest 311 marvin alice ~alice example.org * :Alice")
+	s.Send(":irctest 318 marvin alice :End of /WHOIS list.")
+	s.Send(":irctest 263 marvin WHOIS :Server load is temporarily too heavy")
+
+	var numeric *irc.NumericError
+	if result := <-pending; !errors.As(result.err, &numeric) || numeric.Code != "263" {
+		t.Fatalf("err = %v, want RPL_TRYAGAIN", result.err)
+	}
+}
+
+func TestQueryLabeled(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.Caps = []string{"batch", "labeled-response"}
+	if err := s.Register("marvin"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	first := whois(ctx, s, "alice")
+	if err := s.Expect("@label=marvin1 WHOIS alice"); err != nil {
+		t.Fatal(err)
+	}
+	second := whois(ctx, s, "alice")
+	if err := s.Expect("@label=marvin2 WHOIS alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Labeled responses may be sent in any order. Replies outside
+	// of the batch are ignored.
+	s.Send("@label=marvin2 :irctest 401 marvin alice :No such nick")
+	s.Send("@label=marvin1 :irctest BATCH +b1 labeled-response")
+	s.Send("@batch=b1 :irctest 311 marvin alice ~alice example.org * :Alice")
+	s.Send(":irctest 330 marvin alice mallory :is logged in as")
+	s.Send("@batch=b1 :irctest 318 marvin alice :End of /WHOIS list.")
+	s.Send(":irctest BATCH -b1")
+
+	var numeric *irc.NumericError
+	if result := <-second; !errors.As(result.err, &numeric) || numeric.Code != "401" {
+		t.Fatalf("err = %v, want ERR_NOSUCHNICK", result.err)
+	}
+
+	result := <-first
+	if result.err != nil {
+		t.Fatal(result.err)
+	} else if result.info.Host != "example.org" || len(result.info.Account) > 0 {
+		t.Fatalf("info = %+v", result.info)
+	}
+}
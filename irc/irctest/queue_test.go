@@ -0,0 +1,85 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irctest
+
+import (
+	"github.com/nmeum/marvin/irc"
+	"testing"
+	"time"
+)
+
+func TestFloodControl(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	if err := s.Register("marvin"); err != nil {
+		t.Fatal(err)
+	}
+
+	period := 200 * time.Millisecond
+	s.Client.SetLimits(irc.Limits{Lines: 1, Period: period})
+
+	// The first line exhausts the bucket.
+	start := time.Now()
+	s.Client.Write("PRIVMSG #chan :first")
+	if err := s.Expect("PRIVMSG #chan :first"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lines with a higher priority are sent first once the bucket
+	// was refilled.
+	s.Client.WritePriority(irc.PriorityLow, "PRIVMSG #chan :low")
+	s.Client.Write("PRIVMSG #chan :normal")
+	s.Client.WritePriority(irc.PriorityHigh, "PONG :token")
+	for _, want := range []string{"PONG :token", "PRIVMSG #chan :normal",
+		"PRIVMSG #chan :low"} {
+		if err := s.Expect(want); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 2*period {
+		t.Fatalf("lines sent after %v, want at least %v", elapsed, 2*period)
+	}
+}
+
+func TestQuitDrain(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	if err := s.Register("marvin"); err != nil {
+		t.Fatal(err)
+	}
+	s.Client.SetLimits(irc.Limits{Lines: 1, Period: time.Hour})
+
+	s.Client.Write("PRIVMSG #chan :sent")
+	s.Client.Write("PRIVMSG #chan :pending")
+	if err := s.Expect("PRIVMSG #chan :sent"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pending lines can't be sent before the deadline passes.
+	start := time.Now()
+	timeout := 100 * time.Millisecond
+	if err := s.Client.Quit("bye", timeout); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < timeout || elapsed > 10*timeout {
+		t.Fatalf("Quit returned after %v, want %v", elapsed, timeout)
+	}
+	if line, err := s.Next(); err == nil {
+		t.Fatalf("unexpected line %q after quitting", line)
+	}
+}
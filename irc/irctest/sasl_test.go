@@ -0,0 +1,87 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irctest
+
+import (
+	"errors"
+	"github.com/nmeum/marvin/irc"
+	"testing"
+)
+
+// startSASL starts the registration of a client authenticating using
+// SASL PLAIN, offering the given capabilities.
+func startSASL(t *testing.T, s *Server, caps string) {
+	if err := s.Client.Authenticate(irc.SASLPlain, "marvin", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Client.Setup("marvin", "irctest", "irctest")
+	for _, want := range []string{"CAP LS 302", "USER ", "NICK marvin"} {
+		if _, err := s.Skip(want); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.Send(":irctest CAP * LS :%s", caps)
+}
+
+func TestSASLOtherCapRefused(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	startSASL(t, s, "multi-prefix sasl=PLAIN")
+	if err := s.Expect("CAP REQ :sasl"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Expect("CAP REQ :multi-prefix"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Send(":irctest CAP * NAK :multi-prefix")
+	s.Send(":irctest CAP * ACK :sasl")
+	if err := s.Expect("AUTHENTICATE PLAIN"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Send("AUTHENTICATE +")
+	if err := s.Expect("AUTHENTICATE bWFydmluAG1hcnZpbgBzZWNyZXQ="); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Send(":irctest 903 marvin :SASL authentication successful")
+	if err := s.Expect("CAP END"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Client.Fatal(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSASLRefused(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	startSASL(t, s, "sasl=PLAIN")
+	if err := s.Expect("CAP REQ :sasl"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Send(":irctest CAP * NAK :sasl")
+	if _, err := s.Skip("QUIT "); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Client.Fatal(); !errors.Is(err, irc.ErrSASLFailed) {
+		t.Fatalf("Fatal() = %v, want %v", err, irc.ErrSASLFailed)
+	}
+}
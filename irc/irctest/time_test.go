@@ -0,0 +1,48 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irctest
+
+import (
+	"github.com/nmeum/marvin/irc"
+	"testing"
+	"time"
+)
+
+func TestSendAt(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.Caps = []string{"server-time"}
+	if err := s.Register("marvin"); err != nil {
+		t.Fatal(err)
+	}
+
+	times := make(chan time.Time, 2)
+	s.Client.CmdHook("privmsg", func(client *irc.Client, msg irc.Message) error {
+		times <- msg.Time()
+		return nil
+	})
+
+	sent := time.Date(2020, time.January, 1, 12, 30, 0, 0, time.UTC)
+	s.SendAt(sent, ":%s PRIVMSG marvin :hello", Prefix("alice"))
+	if received := <-times; !received.Equal(sent) {
+		t.Errorf("time = %v, want %v", received, sent)
+	}
+
+	before := time.Now()
+	s.Privmsg("alice", "marvin", "hello")
+	if received := <-times; received.Before(before) {
+		t.Errorf("time without tag = %v, want current time", received)
+	}
+}
@@ -0,0 +1,241 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Values of the parameters applied to the state until the server
+// advertises different ones or after it removed them.
+var isupportDefaults = map[string]string{
+	"CASEMAPPING": "rfc1459",
+	"CHANMODES":   "beI,k,l,",
+	"CHANTYPES":   "#&",
+	"PREFIX":      "(ov)@+",
+}
+
+// ISupport returns the value of the given parameter advertised by the
+// server using RPL_ISUPPORT. The second return value is false if the
+// parameter wasn't advertised.
+func (c *Client) ISupport(name string) (string, bool) {
+	c.state.mtx.RLock()
+	defer c.state.mtx.RUnlock()
+
+	value, ok := c.state.isupport[strings.ToUpper(name)]
+	return value, ok
+}
+
+// Network returns the name of the network the client is connected to.
+// This is the name the client was added to a Group with or, if none,
+// the name advertised by the server.
+func (c *Client) Network() string {
+	c.groupMtx.Lock()
+	network := c.network
+	c.groupMtx.Unlock()
+
+	if len(network) > 0 {
+		return network
+	}
+
+	name, _ := c.ISupport("NETWORK")
+	return name
+}
+
+// NickLen returns the maximum length of nicknames or zero if unknown.
+func (c *Client) NickLen() int {
+	value, _ := c.ISupport("NICKLEN")
+	n, _ := strconv.Atoi(value)
+	return n
+}
+
+// TargMax returns the maximum amount of targets allowed for the given
+// command or zero if unlimited or unknown.
+func (c *Client) TargMax(cmd string) int {
+	value, _ := c.ISupport("TARGMAX")
+	for _, target := range strings.Split(value, ",") {
+		name, max := cutByte(target, ':')
+		if strings.EqualFold(name, cmd) {
+			n, _ := strconv.Atoi(max)
+			return n
+		}
+	}
+
+	return 0
+}
+
+// Fold returns the given nickname or channel name folded using the
+// casemapping of the server. Folded names can be compared directly.
+func (c *Client) Fold(name string) string {
+	c.state.mtx.RLock()
+	defer c.state.mtx.RUnlock()
+
+	return c.state.fold(name)
+}
+
+// EqualFold reports whether the given nicknames or channel names are
+// equal under the casemapping of the server.
+func (c *Client) EqualFold(a, b string) bool {
+	c.state.mtx.RLock()
+	defer c.state.mtx.RUnlock()
+
+	return c.state.fold(a) == c.state.fold(b)
+}
+
+// foldCase folds the given name using the given casemapping. Unknown
+// casemappings are treated like rfc1459.
+func foldCase(casemapping, name string) string {
+	switch casemapping {
+	case "ascii":
+		return strings.Map(func(r rune) rune {
+			if r >= 'A' && r <= 'Z' {
+				return r + 'a' - 'A'
+			}
+			return r
+		}, name)
+	case "rfc7613":
+		return strings.ToLower(name)
+	}
+
+	strict := casemapping == "strict-rfc1459"
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		case r == '[':
+			return '{'
+		case r == ']':
+			return '}'
+		case r == '\\':
+			return '|'
+		case r == '~' && !strict:
+			return '^'
+		}
+		return r
+	}, name)
+}
+
+// unescapeValue replaces the \xHH escapes used in RPL_ISUPPORT values.
+func unescapeValue(value string) string {
+	if !strings.Contains(value, `\x`) {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if i+4 <= len(value) && strings.HasPrefix(value[i:], `\x`) {
+			if n, err := strconv.ParseUint(value[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(value[i])
+	}
+
+	return b.String()
+}
+
+// cutByte splits the given string at the first occurrence of sep.
+func cutByte(s string, sep byte) (string, string) {
+	if idx := strings.IndexByte(s, sep); idx >= 0 {
+		return s[:idx], s[idx+1:]
+	}
+
+	return s, ""
+}
+
+// apply updates the state according to the given RPL_ISUPPORT
+// parameter. The caller must hold the lock.
+func (s *state) apply(name, value string) {
+	switch name {
+	case "CHANTYPES":
+		s.chanTypes = value
+	case "PREFIX":
+		modes, symbols := cutByte(strings.TrimPrefix(value, "("), ')')
+		if len(modes) == len(symbols) {
+			s.prefixModes, s.prefixSymbols = modes, symbols
+		}
+	case "CHANMODES":
+		modes := strings.Split(value, ",")
+		if len(modes) >= 3 {
+			s.listModes, s.paramModes, s.setParamModes = modes[0], modes[1], modes[2]
+		}
+	case "CASEMAPPING":
+		s.casemapping = strings.ToLower(value)
+		s.refold()
+	}
+}
+
+// refold rebuilds all maps keyed by folded names after the casemapping
+// changed. The caller must hold the lock.
+func (s *state) refold() {
+	accounts := make(map[string]string)
+	for key, account := range s.accounts {
+		accounts[s.fold(key)] = account
+	}
+
+	channels := make(map[string]*channel)
+	for _, ch := range s.channels {
+		members := make(map[string]*Member)
+		for key, member := range ch.members {
+			members[s.fold(member.Nick)] = member
+
+			// Old keys may not fold to the new key of the nick.
+			if account, ok := s.accounts[key]; ok {
+				delete(accounts, s.fold(key))
+				accounts[s.fold(member.Nick)] = account
+			}
+		}
+
+		ch.members = members
+		channels[s.fold(ch.name)] = ch
+	}
+
+	s.channels = channels
+	s.accounts = accounts
+}
+
+// isupportCmd handles RPL_ISUPPORT.
+func isupportCmd(client *Client, msg Message) error {
+	if len(msg.Params) < 3 {
+		return nil
+	}
+
+	s := client.state
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	// First parameter is our nick, last one a human-readable text.
+	for _, param := range msg.Params[1 : len(msg.Params)-1] {
+		name, value := cutByte(param, '=')
+		name = strings.ToUpper(name)
+
+		if strings.HasPrefix(name, "-") {
+			name = name[1:]
+			delete(s.isupport, name)
+			if value, ok := isupportDefaults[name]; ok {
+				s.apply(name, value)
+			}
+			continue
+		}
+
+		value = unescapeValue(value)
+		s.isupport[name] = value
+		s.apply(name, value)
+	}
+
+	return nil
+}
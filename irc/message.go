@@ -14,53 +14,195 @@
 package irc
 
 import (
+	"github.com/nmeum/marvin/irc/format"
 	"strings"
+	"time"
 )
 
+// Maximum amount of middle parameters as defined in RFC 2812.
+const maxMiddle = 14
+
 type Sender struct {
 	Name string
+
+	// User and host of the sender as user@host, empty for servers.
 	Host string
+
+	// Parts of Host, either may be empty.
+	User     string
+	Hostname string
 }
 
 type Message struct {
-	Sender   Sender
+	// IRCv3 message tags, values are already unescaped.
+	Tags map[string]string
+
+	// Prefix of the message, Sender.Name is either a nickname or
+	// a server name.
+	Sender Sender
+
+	// Command in lower case, e.g. "privmsg" or "001".
+	Command string
+
+	// All parameters including the trailing one.
+	Params []string
+
+	// First parameter, usually the target of the command.
 	Receiver string
-	Command  string
-	Data     string
+
+	// Last parameter, usually the trailing parameter. Formatting
+	// is removed from the text of PRIVMSGs and NOTICEs.
+	Data string
+
+	// Services account of the sender, empty if the sender isn't
+	// logged in or the account is unknown.
+	Account string
+}
+
+// Param returns the parameter at the given index or an empty string
+// if the message doesn't have that many parameters.
+func (m Message) Param(idx int) string {
+	if idx < 0 || idx >= len(m.Params) {
+		return ""
+	}
+
+	return m.Params[idx]
+}
+
+// Time returns the time the message was sent at according to the
+// server-time tag or the current time if the tag is missing.
+func (m Message) Time() time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, m.Tags["time"]); err == nil {
+		return t
+	}
+
+	return time.Now()
 }
 
 func parseMessage(line string) (msg Message) {
-	if len(strings.Fields(line)) < 2 {
-		return
+	line = strings.TrimLeft(line, " ")
+	if strings.HasPrefix(line, "@") {
+		var tags string
+		tags, line = cut(line[1:])
+		msg.Tags = parseTags(tags)
 	}
 
 	if strings.HasPrefix(line, ":") {
-		idx := strings.Index(line, " ")
-		msg.Sender = Sender{Name: line[1:idx]}
+		var prefix string
+		prefix, line = cut(line[1:])
+		msg.Sender = parsePrefix(prefix)
+	}
+
+	var cmd string
+	cmd, line = cut(line)
+	if len(cmd) <= 0 {
+		return Message{}
+	}
+	msg.Command = strings.ToLower(cmd)
 
-		user := strings.Split(msg.Sender.Name, "!")
-		if len(user) >= 2 {
-			msg.Sender.Name = user[0]
-			msg.Sender.Host = user[1]
+	for len(line) > 0 {
+		if strings.HasPrefix(line, ":") || len(msg.Params) >= maxMiddle {
+			msg.Params = append(msg.Params, strings.TrimPrefix(line, ":"))
+			break
 		}
 
-		line = line[idx+1:]
+		var param string
+		param, line = cut(line)
+		msg.Params = append(msg.Params, param)
 	}
 
-	idx := strings.Index(line, " ")
-	msg.Command = strings.ToLower(line[:idx])
-	line = line[idx+1:]
-
-	if strings.Contains(line, " ") {
-		idx = strings.Index(line, ":")
-		if idx >= 0 {
-			msg.Receiver = strings.TrimSpace(line[0:idx])
-			msg.Data = line[idx+1:]
+	if len(msg.Params) > 0 {
+		msg.Receiver = msg.Params[0]
+		msg.Data = msg.Params[len(msg.Params)-1]
+		if msg.Command == "privmsg" || msg.Command == "notice" {
+			msg.Data = format.Strip(msg.Data)
 		}
-	} else {
-		msg.Data = line
+		msg.Data = strings.TrimSpace(msg.Data)
 	}
 
-	msg.Data = strings.TrimSpace(msg.Data)
 	return
 }
+
+func parsePrefix(prefix string) (sender Sender) {
+	if idx := strings.IndexAny(prefix, "!@"); idx >= 0 {
+		sender.Host = prefix[idx+1:]
+	}
+
+	if idx := strings.Index(prefix, "@"); idx >= 0 {
+		sender.Hostname = prefix[idx+1:]
+		prefix = prefix[:idx]
+	}
+
+	if idx := strings.Index(prefix, "!"); idx >= 0 {
+		sender.User = prefix[idx+1:]
+		prefix = prefix[:idx]
+	}
+
+	sender.Name = prefix
+	return
+}
+
+func parseTags(tags string) map[string]string {
+	m := make(map[string]string)
+	for _, tag := range strings.Split(tags, ";") {
+		if len(tag) <= 0 {
+			continue
+		}
+
+		var key, value string
+		if idx := strings.Index(tag, "="); idx >= 0 {
+			key, value = tag[:idx], unescapeTag(tag[idx+1:])
+		} else {
+			key = tag
+		}
+
+		m[key] = value
+	}
+
+	return m
+}
+
+// unescapeTag unescapes a tag value as described in the IRCv3 message
+// tags specification. Invalid escapes are replaced by the escaped
+// character and a trailing backslash is dropped.
+func unescapeTag(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		} else if i++; i >= len(value) {
+			break
+		}
+
+		switch value[i] {
+		case ':':
+			b.WriteByte(';')
+		case 's':
+			b.WriteByte(' ')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+
+	return b.String()
+}
+
+// cut splits the given string at the first space and returns the
+// part before the space and the remainder without leading spaces.
+func cut(s string) (string, string) {
+	idx := strings.Index(s, " ")
+	if idx < 0 {
+		return s, ""
+	}
+
+	return s[:idx], strings.TrimLeft(s[idx+1:], " ")
+}
@@ -0,0 +1,114 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		line string
+		want Message
+	}{
+		{
+			line: "@time=2020-01-01T00:00:00.000Z;msgid=a\\sb\\:c\\\\d\\ :nick!user@host PRIVMSG #chan :hello world",
+			want: Message{
+				Tags:     map[string]string{"time": "2020-01-01T00:00:00.000Z", "msgid": "a b;c\\d"},
+				Sender:   Sender{Name: "nick", Host: "user@host", User: "user", Hostname: "host"},
+				Command:  "privmsg",
+				Params:   []string{"#chan", "hello world"},
+				Receiver: "#chan",
+				Data:     "hello world",
+			},
+		},
+		{
+			line: "@draft/flag :irc.example.org 001 marvin :Welcome",
+			want: Message{
+				Tags:     map[string]string{"draft/flag": ""},
+				Sender:   Sender{Name: "irc.example.org"},
+				Command:  "001",
+				Params:   []string{"marvin", "Welcome"},
+				Receiver: "marvin",
+				Data:     "Welcome",
+			},
+		},
+		{
+			line: ":nick@host JOIN #chan",
+			want: Message{
+				Sender:   Sender{Name: "nick", Host: "host", Hostname: "host"},
+				Command:  "join",
+				Params:   []string{"#chan"},
+				Receiver: "#chan",
+				Data:     "#chan",
+			},
+		},
+		{
+			line: "TOPIC #chan :",
+			want: Message{
+				Command:  "topic",
+				Params:   []string{"#chan", ""},
+				Receiver: "#chan",
+			},
+		},
+		{
+			line: "CMD 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16",
+			want: Message{
+				Command: "cmd",
+				Params: []string{"1", "2", "3", "4", "5", "6", "7",
+					"8", "9", "10", "11", "12", "13", "14", "15 16"},
+				Receiver: "1",
+				Data:     "15 16",
+			},
+		},
+		{
+			line: ":nick!user@host NOTICE marvin :\x02bold\x02 \x0304,01red\x03",
+			want: Message{
+				Sender:   Sender{Name: "nick", Host: "user@host", User: "user", Hostname: "host"},
+				Command:  "notice",
+				Params:   []string{"marvin", "\x02bold\x02 \x0304,01red\x03"},
+				Receiver: "marvin",
+				Data:     "bold red",
+			},
+		},
+		{
+			line: ":nick!user@host",
+			want: Message{},
+		},
+	}
+
+	for _, test := range tests {
+		msg := parseMessage(test.line)
+		if !reflect.DeepEqual(msg, test.want) {
+			t.Errorf("parseMessage(%q) = %+v, want %+v", test.line, msg, test.want)
+		}
+	}
+}
+
+func TestMessageTime(t *testing.T) {
+	msg := parseMessage("@time=2020-01-01T12:30:00.123Z PING :x")
+	want := time.Date(2020, time.January, 1, 12, 30, 0, 123000000, time.UTC)
+	if got := msg.Time(); !got.Equal(want) {
+		t.Errorf("Time() = %v, want %v", got, want)
+	}
+
+	before := time.Now()
+	for _, line := range []string{"PING :x", "@time=invalid PING :x"} {
+		if got := parseMessage(line).Time(); got.Before(before) {
+			t.Errorf("Time() of %q = %v, want current time", line, got)
+		}
+	}
+}
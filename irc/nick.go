@@ -0,0 +1,187 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// Default interval between attempts to regain the primary nickname.
+	defaultRegainInterval = 5 * time.Minute
+
+	// Maximum amount of nicknames derived from the primary nickname
+	// which are tried after all alternative nicknames.
+	maxNickFallbacks = 5
+
+	// Maximum nickname length as defined in RFC 2812, used if the
+	// server rejected a nickname before advertising its limit.
+	defaultNickLen = 9
+)
+
+// ErrNickUnavailable is returned if no nickname was accepted by the
+// server during registration.
+var ErrNickUnavailable = errors.New("no nickname accepted by the server")
+
+type nickState struct {
+	// Current nickname.
+	current string
+
+	// Nickname passed to Setup.
+	primary string
+
+	// Index of the last alternative nickname tried and amount of
+	// nicknames derived from the primary one tried afterwards.
+	alt      int
+	fallback int
+
+	// Whether the registration on the current connection completed.
+	registered bool
+}
+
+// Nick returns the current nickname of the client.
+func (c *Client) Nick() string {
+	c.nickMtx.Lock()
+	defer c.nickMtx.Unlock()
+
+	return c.nick.current
+}
+
+// PrimaryNick returns the nickname the client should preferably use.
+func (c *Client) PrimaryNick() string {
+	c.nickMtx.Lock()
+	defer c.nickMtx.Unlock()
+
+	return c.nick.primary
+}
+
+// Registered reports whether the registration on the current
+// connection completed.
+func (c *Client) Registered() bool {
+	c.nickMtx.Lock()
+	defer c.nickMtx.Unlock()
+
+	return c.nick.registered
+}
+
+// regain periodically tries to change the current nickname to the
+// primary nickname if the primary one couldn't be used.
+func (c *Client) regain() {
+	for {
+		interval := c.RegainInterval
+		if interval <= 0 {
+			interval = defaultRegainInterval
+		}
+		if !c.sleep(interval) {
+			return
+		}
+
+		c.nickMtx.Lock()
+		n := c.nick
+		c.nickMtx.Unlock()
+
+		if n.registered && !c.EqualFold(n.current, n.primary) {
+			c.Write("NICK %s", n.primary)
+		}
+	}
+}
+
+// nickInUseCmd handles errors caused by an unavailable nickname during
+// registration by trying the alternative nicknames in order. If all of
+// them are unavailable underscores are appended to the primary one. All
+// nicknames are truncated to the maximum length. If none of them is
+// accepted the connection is closed.
+func nickInUseCmd(client *Client, msg Message) error {
+	client.nickMtx.Lock()
+	defer client.nickMtx.Unlock()
+
+	n := &client.nick
+	if n.registered {
+		return nil // Attempt to regain the primary nickname failed
+	}
+
+	limit := client.NickLen()
+	if limit <= 0 && msg.Command == "432" {
+		limit = defaultNickLen
+	}
+
+	if n.alt < len(client.AltNicks) {
+		n.current = truncate(client.AltNicks[n.alt], limit)
+		n.alt++
+	} else if n.fallback < maxNickFallbacks {
+		n.fallback++
+		suffix := strings.Repeat("_", n.fallback)
+		n.current = truncate(n.primary, limit-len(suffix)) + suffix
+	} else {
+		client.disconnect()
+		return ErrNickUnavailable
+	}
+
+	return client.Write("NICK %s", n.current)
+}
+
+// truncate shortens the given nickname to the given length if it is
+// positive.
+func truncate(nick string, length int) string {
+	if length > 0 && len(nick) > length {
+		return nick[:length]
+	}
+
+	return nick
+}
+
+func nickWelcomeCmd(client *Client, msg Message) error {
+	client.nickMtx.Lock()
+	defer client.nickMtx.Unlock()
+
+	client.nick.registered = true
+	if len(msg.Receiver) > 0 {
+		client.nick.current = msg.Receiver
+	}
+
+	client.regainOnce.Do(func() { go client.regain() })
+	return nil
+}
+
+func nickChangeCmd(client *Client, msg Message) error {
+	client.nickMtx.Lock()
+	defer client.nickMtx.Unlock()
+
+	n := &client.nick
+	if client.EqualFold(msg.Sender.Name, n.current) {
+		n.current = msg.Receiver
+	} else if client.EqualFold(msg.Sender.Name, n.primary) && n.registered {
+		return client.Write("NICK %s", n.primary)
+	}
+
+	return nil
+}
+
+// nickQuitCmd tries to regain the primary nickname as soon as the
+// user using it quits.
+func nickQuitCmd(client *Client, msg Message) error {
+	client.nickMtx.Lock()
+	defer client.nickMtx.Unlock()
+
+	n := client.nick
+	if !n.registered || !client.EqualFold(msg.Sender.Name, n.primary) {
+		return nil
+	} else if client.EqualFold(n.current, n.primary) {
+		return nil
+	}
+
+	return client.Write("NICK %s", n.primary)
+}
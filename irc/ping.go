@@ -0,0 +1,102 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// Default interval between PINGs sent by the client.
+	defaultPingInterval = 2 * time.Minute
+
+	// Default time to wait for a PONG before the connection is
+	// considered dead.
+	defaultPingTimeout = 1 * time.Minute
+)
+
+type pingState struct {
+	// Token of the PING not answered yet, empty if none.
+	token string
+	sent  time.Time
+
+	// Round-trip time of the last answered PING.
+	lag time.Duration
+}
+
+// Lag returns the round-trip time of the last PING sent by the client.
+func (c *Client) Lag() time.Duration {
+	c.pingMtx.Lock()
+	defer c.pingMtx.Unlock()
+
+	return c.ping.lag
+}
+
+// keepalive periodically sends a PING to the server and closes the
+// connection if no matching PONG is received within the timeout.
+func (c *Client) keepalive() {
+	for {
+		interval, timeout := c.PingInterval, c.PingTimeout
+		if interval <= 0 {
+			interval = defaultPingInterval
+		}
+		if timeout <= 0 {
+			timeout = defaultPingTimeout
+		}
+
+		if !c.sleep(interval) {
+			return
+		} else if !c.Registered() {
+			continue
+		}
+
+		token := fmt.Sprintf("marvin-%d", time.Now().UnixNano())
+		c.pingMtx.Lock()
+		c.ping.token, c.ping.sent = token, time.Now()
+		c.pingMtx.Unlock()
+
+		c.Write("PING :%s", token)
+		if !c.sleep(timeout) {
+			return
+		}
+
+		c.pingMtx.Lock()
+		dead := c.ping.token == token
+		c.pingMtx.Unlock()
+
+		if dead {
+			c.disconnect()
+		}
+	}
+}
+
+func pongCmd(client *Client, msg Message) error {
+	client.pingMtx.Lock()
+	defer client.pingMtx.Unlock()
+
+	if len(client.ping.token) <= 0 || msg.Data != client.ping.token {
+		return nil
+	}
+
+	client.ping.lag = time.Since(client.ping.sent)
+	client.ping.token = ""
+
+	return nil
+}
+
+func pingWelcomeCmd(client *Client, msg Message) error {
+	client.pingOnce.Do(func() { go client.keepalive() })
+	return nil
+}
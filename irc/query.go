@@ -0,0 +1,485 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrDisconnected is returned by queries which were still pending when
+// the connection was replaced.
+var ErrDisconnected = errors.New("connection replaced before reply")
+
+// ErrNoReply is returned by queries if the server acknowledged the
+// command without sending the expected reply.
+var ErrNoReply = errors.New("no reply received")
+
+// NumericError is returned by queries if the server answered with an
+// error numeric, e.g. ERR_NOSUCHNICK.
+type NumericError struct {
+	Code string
+	Text string
+}
+
+func (e *NumericError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Text)
+}
+
+// WhoisInfo is the result of a WHOIS query.
+type WhoisInfo struct {
+	Nick     string
+	User     string
+	Host     string
+	Realname string
+
+	// Server the user is connected to and its description.
+	Server     string
+	ServerInfo string
+
+	// Account the user is logged in to, empty if none.
+	Account string
+
+	// Away message, empty if the user isn't away.
+	Away string
+
+	// Channels including membership prefixes, e.g. "@#foo".
+	Channels []string
+
+	Idle     time.Duration
+	SignOn   time.Time
+	Operator bool
+	Secure   bool
+}
+
+// WhoReply is a single reply to a WHO query.
+type WhoReply struct {
+	Channel  string
+	Nick     string
+	User     string
+	Host     string
+	Server   string
+	Realname string
+	Hops     int
+
+	// Membership prefixes in the channel, e.g. "@".
+	Prefixes string
+
+	Away     bool
+	Operator bool
+}
+
+// Error numerics which may be sent in reply to any query. Except for
+// ERR_NOPRIVILEGES they mention the command of the query.
+var queryErrors = map[string]bool{
+	"263": true, // RPL_TRYAGAIN
+	"416": true, // ERR_TOOMANYMATCHES
+	"421": true, // ERR_UNKNOWNCOMMAND
+	"481": true, // ERR_NOPRIVILEGES
+}
+
+type query struct {
+	// Command sent, e.g. WHOIS, and whether the caller stopped
+	// waiting for the reply.
+	cmd     string
+	expired bool
+
+	// Label sent with the command if labeled-response is enabled
+	// and reference of the batch containing the response.
+	label string
+	batch string
+
+	// Parameter which must be present in unlabeled replies, empty
+	// if replies are matched in order only.
+	target string
+
+	// Mask which must be the first parameter after our nick of the
+	// unlabeled end numeric, e.g. RPL_ENDOFWHO, empty if any.
+	mask string
+
+	// Numerics collected, completing the query and completing it
+	// with an error.
+	replies map[string]bool
+	end     map[string]bool
+	errors  map[string]bool
+
+	msgs []Message
+	err  error
+	done chan struct{}
+}
+
+func newQuery(target string, replies, end, errors []string) *query {
+	set := func(cmds []string) map[string]bool {
+		m := make(map[string]bool)
+		for _, cmd := range cmds {
+			m[cmd] = true
+		}
+		return m
+	}
+
+	return &query{
+		target:  target,
+		replies: set(replies),
+		end:     set(end),
+		errors:  set(errors),
+		done:    make(chan struct{}),
+	}
+}
+
+// add adds the given reply to the query and reports whether the
+// query is complete afterwards.
+func (q *query) add(msg Message) bool {
+	if q.errors[msg.Command] || queryErrors[msg.Command] {
+		q.err = &NumericError{msg.Command, msg.Data}
+		return true
+	}
+
+	if q.replies[msg.Command] {
+		q.msgs = append(q.msgs, msg)
+	}
+
+	return q.end[msg.Command]
+}
+
+// accepts reports whether the given unlabeled reply belongs to the
+// query. The caller must not hold the state lock.
+func (c *Client) accepts(q *query, msg Message) bool {
+	if msg.Command == "481" {
+		return true
+	} else if queryErrors[msg.Command] {
+		return strings.EqualFold(msg.Param(1), q.cmd)
+	}
+
+	if !q.replies[msg.Command] && !q.end[msg.Command] && !q.errors[msg.Command] {
+		return false
+	} else if q.end[msg.Command] && len(q.mask) > 0 {
+		return c.EqualFold(msg.Param(1), q.mask)
+	} else if len(q.target) <= 0 {
+		return true
+	}
+
+	// The target is one of the middle parameters after our nick.
+	for i := 1; i < len(msg.Params)-1; i++ {
+		if c.EqualFold(msg.Params[i], q.target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// query sends the given command and waits until the server replied.
+// If labeled-response is enabled the replies are correlated using a
+// label, otherwise queries are answered in the order they were sent.
+// Since replies are collected by Handle, queries must not be made by
+// synchronous hooks.
+func (c *Client) query(ctx context.Context, q *query, format string, argv ...interface{}) ([]Message, error) {
+	cmd := fmt.Sprintf(format, argv...)
+	q.cmd, _ = cut(cmd)
+
+	c.queryMtx.Lock()
+	if c.HasCap("labeled-response") {
+		c.labels++
+		q.label = fmt.Sprintf("marvin%d", c.labels)
+		cmd = fmt.Sprintf("@label=%s %s", q.label, cmd)
+	}
+
+	// Queued while holding the lock to preserve the order.
+	if err := c.Write("%s", cmd); err != nil {
+		c.queryMtx.Unlock()
+		return nil, err
+	}
+	c.queries = append(c.queries, q)
+	c.queryMtx.Unlock()
+
+	select {
+	case <-q.done:
+		return q.msgs, q.err
+	case <-ctx.Done():
+		// Unlabeled queries are kept until they are answered to
+		// prevent their replies from being passed to other ones.
+		c.queryMtx.Lock()
+		if len(q.label) > 0 {
+			c.removeQuery(q)
+		} else {
+			q.expired = true
+		}
+		c.queryMtx.Unlock()
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, c.ctx.Err()
+	}
+}
+
+// collect passes the given message to the pending query it belongs
+// to, if any.
+func (c *Client) collect(msg Message) {
+	c.queryMtx.Lock()
+	defer c.queryMtx.Unlock()
+
+	if len(c.queries) <= 0 {
+		return
+	}
+
+	if label, ok := msg.Tags["label"]; ok {
+		q := c.findQuery(func(q *query) bool { return q.label == label })
+		if q == nil {
+			return
+		}
+
+		if msg.Command == "batch" && strings.HasPrefix(msg.Receiver, "+") {
+			q.batch = msg.Receiver[1:]
+			return
+		}
+
+		// A labeled response without a batch is a single line.
+		if msg.Command != "ack" {
+			q.add(msg)
+		}
+		c.completeQuery(q)
+		return
+	}
+
+	if ref, ok := msg.Tags["batch"]; ok {
+		q := c.findQuery(func(q *query) bool { return len(q.batch) > 0 && q.batch == ref })
+		if q != nil && q.add(msg) {
+			c.completeQuery(q)
+		}
+		return
+	}
+
+	if msg.Command == "batch" && strings.HasPrefix(msg.Receiver, "-") {
+		ref := msg.Receiver[1:]
+		if q := c.findQuery(func(q *query) bool { return q.batch == ref }); q != nil {
+			c.completeQuery(q)
+		}
+		return
+	}
+
+	q := c.findQuery(func(q *query) bool { return len(q.label) <= 0 && c.accepts(q, msg) })
+	if q != nil && q.add(msg) {
+		c.completeQuery(q)
+	}
+}
+
+// findQuery returns the oldest pending query matching the given
+// function. The caller must hold the lock.
+func (c *Client) findQuery(match func(*query) bool) *query {
+	for _, q := range c.queries {
+		if match(q) {
+			return q
+		}
+	}
+
+	return nil
+}
+
+// completeQuery wakes up the caller waiting for the given query. The
+// caller must hold the lock.
+func (c *Client) completeQuery(q *query) {
+	var pending []*query
+	before := true
+	for _, p := range c.queries {
+		if p == q {
+			before = false
+			continue
+		}
+
+		// Since the server answers in order, expired queries of
+		// the same kind sent before this one won't be answered.
+		stale := before && p.expired && p.cmd == q.cmd
+		if !stale || len(p.label) > 0 || len(q.label) > 0 {
+			pending = append(pending, p)
+		}
+	}
+
+	c.queries = pending
+	close(q.done)
+}
+
+// removeQuery removes the given query from the pending queries. The
+// caller must hold the lock.
+func (c *Client) removeQuery(q *query) {
+	for i, p := range c.queries {
+		if p == q {
+			c.queries = append(c.queries[:i:i], c.queries[i+1:]...)
+			return
+		}
+	}
+}
+
+// failQueries completes all pending queries with the given error.
+func (c *Client) failQueries(err error) {
+	c.queryMtx.Lock()
+	defer c.queryMtx.Unlock()
+
+	for _, q := range c.queries {
+		q.err = err
+		close(q.done)
+	}
+	c.queries = nil
+}
+
+// Whois sends a WHOIS query for the given nick and returns the
+// information sent by the server.
+func (c *Client) Whois(ctx context.Context, nick string) (WhoisInfo, error) {
+	q := newQuery(nick, []string{"301", "311", "312", "313", "317", "319", "330", "671", "401", "402"},
+		[]string{"318"}, nil)
+
+	msgs, err := c.query(ctx, q, "WHOIS %s", nick)
+	if err != nil {
+		return WhoisInfo{}, err
+	}
+
+	info := WhoisInfo{Nick: nick}
+	for _, msg := range msgs {
+		switch msg.Command {
+		case "401", "402":
+			// ERR_NOSUCHNICK is followed by RPL_ENDOFWHOIS.
+			return WhoisInfo{}, &NumericError{msg.Command, msg.Data}
+		case "311":
+			info.Nick, info.User, info.Host = msg.Param(1), msg.Param(2), msg.Param(3)
+			info.Realname = msg.Param(5)
+		case "312":
+			info.Server, info.ServerInfo = msg.Param(2), msg.Param(3)
+		case "313":
+			info.Operator = true
+		case "317":
+			if secs, err := strconv.Atoi(msg.Param(2)); err == nil {
+				info.Idle = time.Duration(secs) * time.Second
+			}
+			if secs, err := strconv.ParseInt(msg.Param(3), 10, 64); err == nil && len(msg.Params) > 4 {
+				info.SignOn = time.Unix(secs, 0)
+			}
+		case "319":
+			info.Channels = append(info.Channels, strings.Fields(msg.Param(2))...)
+		case "330":
+			info.Account = msg.Param(2)
+		case "301":
+			info.Away = msg.Param(2)
+		case "671":
+			info.Secure = true
+		}
+	}
+
+	return info, nil
+}
+
+// Who sends a WHO query for the given mask, e.g. a channel, and
+// returns the replies sent by the server.
+func (c *Client) Who(ctx context.Context, mask string) ([]WhoReply, error) {
+	q := newQuery("", []string{"352"}, []string{"315"}, []string{"402"})
+	q.mask = mask
+
+	msgs, err := c.query(ctx, q, "WHO %s", mask)
+	if err != nil {
+		return nil, err
+	}
+
+	c.state.mtx.RLock()
+	symbols := c.state.prefixSymbols
+	c.state.mtx.RUnlock()
+
+	var replies []WhoReply
+	for _, msg := range msgs {
+		hops, realname := cut(msg.Param(7))
+		flags := msg.Param(6)
+
+		reply := WhoReply{
+			Channel:  msg.Param(1),
+			User:     msg.Param(2),
+			Host:     msg.Param(3),
+			Server:   msg.Param(4),
+			Nick:     msg.Param(5),
+			Realname: realname,
+			Away:     strings.HasPrefix(flags, "G"),
+			Operator: strings.Contains(flags, "*"),
+		}
+		reply.Hops, _ = strconv.Atoi(hops)
+
+		for _, r := range flags {
+			if strings.ContainsRune(symbols, r) {
+				reply.Prefixes += string(r)
+			}
+		}
+
+		replies = append(replies, reply)
+	}
+
+	return replies, nil
+}
+
+// Names sends a NAMES query for the given channel and returns its
+// members sorted by nick.
+func (c *Client) Names(ctx context.Context, channel string) ([]Member, error) {
+	q := newQuery(channel, []string{"353"}, []string{"366"}, []string{"402"})
+
+	msgs, err := c.query(ctx, q, "NAMES %s", channel)
+	if err != nil {
+		return nil, err
+	}
+
+	c.state.mtx.RLock()
+	defer c.state.mtx.RUnlock()
+
+	var members []Member
+	for _, msg := range msgs {
+		for _, name := range strings.Fields(msg.Param(3)) {
+			nick := strings.TrimLeft(name, c.state.prefixSymbols)
+			prefixes := name[:len(name)-len(nick)]
+
+			if idx := strings.Index(nick, "!"); idx >= 0 {
+				nick = nick[:idx]
+			}
+
+			members = append(members, Member{nick, c.state.addPrefix(prefixes, 0)})
+		}
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Nick < members[j].Nick
+	})
+
+	return members, nil
+}
+
+// ChannelModes queries the modes of the given channel. Parameters of
+// modes which aren't sent by the server, e.g. the key if we aren't in
+// the channel, are empty.
+func (c *Client) ChannelModes(ctx context.Context, name string) (map[rune]string, error) {
+	q := newQuery(name, []string{"324"}, []string{"324"}, []string{"401", "403", "442", "482"})
+
+	msgs, err := c.query(ctx, q, "MODE %s", name)
+	if err != nil {
+		return nil, err
+	} else if len(msgs) <= 0 {
+		return nil, ErrNoReply
+	}
+
+	msg := msgs[0]
+	ch := &channel{modes: make(map[rune]string)}
+
+	c.state.mtx.RLock()
+	if len(msg.Params) > 2 {
+		c.state.applyModes(ch, msg.Params[2], msg.Params[3:])
+	}
+	c.state.mtx.RUnlock()
+
+	return ch.modes, nil
+}
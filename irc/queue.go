@@ -0,0 +1,261 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// Priority of a queued line. Lines with PriorityHigh are never delayed
+// by the rate limit but are still accounted for.
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+	numPriorities
+)
+
+// ErrQueueFull is returned by Write if the send queue is full.
+var ErrQueueFull = errors.New("send queue is full")
+
+// Limits describes a token bucket limiting the rate at which lines
+// are sent to the server.
+type Limits struct {
+	// Maximum amount of lines per period, zero means unlimited.
+	Lines int
+
+	// Maximum amount of bytes per period, zero means unlimited.
+	Bytes int
+
+	// Period after which the bucket is completely refilled.
+	Period time.Duration
+
+	// Maximum amount of queued lines, zero means unlimited.
+	QueueSize int
+
+	// Whether the oldest queued line of a lower or equal priority
+	// should be dropped instead of the new one if the queue is full.
+	DropOldest bool
+}
+
+var DefaultLimits = Limits{
+	Lines:     5,
+	Period:    10 * time.Second,
+	QueueSize: 512,
+}
+
+type queue struct {
+	mtx    sync.Mutex
+	cond   *sync.Cond
+	lanes  [numPriorities][]string
+	limits Limits
+
+	// Whether a popped line is currently being written.
+	busy bool
+
+	// Available tokens of the bucket.
+	lines float64
+	bytes float64
+	last  time.Time
+}
+
+func newQueue(limits Limits) *queue {
+	q := &queue{}
+	q.cond = sync.NewCond(&q.mtx)
+	q.setLimits(limits)
+
+	return q
+}
+
+func (q *queue) setLimits(limits Limits) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.limits = limits
+	q.lines = float64(limits.Lines)
+	q.bytes = float64(limits.Bytes)
+	q.last = time.Now()
+}
+
+func (q *queue) len() (n int) {
+	for _, lane := range q.lanes {
+		n += len(lane)
+	}
+
+	return
+}
+
+func (q *queue) push(prio Priority, line string) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.limits.QueueSize > 0 && q.len() >= q.limits.QueueSize {
+		if !q.evict(prio) {
+			return ErrQueueFull
+		}
+	}
+
+	q.lanes[prio] = append(q.lanes[prio], line)
+	q.cond.Broadcast()
+
+	return nil
+}
+
+// evict drops the oldest line from the lowest priority lane which has
+// a lower priority than the given one. If DropOldest is set lines with
+// an equal priority are dropped as well. The caller must hold the lock.
+func (q *queue) evict(prio Priority) bool {
+	for p := PriorityLow; p <= prio; p++ {
+		if len(q.lanes[p]) <= 0 || (p == prio && !q.limits.DropOldest) {
+			continue
+		}
+
+		q.lanes[p] = q.lanes[p][1:]
+		return true
+	}
+
+	return false
+}
+
+// clear drops all queued lines.
+func (q *queue) clear() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	for p := range q.lanes {
+		q.lanes[p] = nil
+	}
+}
+
+// pop blocks until a line is available and the rate limit allows
+// sending it. The line with the highest priority is returned.
+func (q *queue) pop() string {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	for {
+		for q.len() <= 0 {
+			q.cond.Wait()
+		}
+
+		prio := q.next()
+		line := q.lanes[prio][0]
+
+		delay := q.take(line, prio == PriorityHigh)
+		if delay <= 0 {
+			q.lanes[prio] = q.lanes[prio][1:]
+			q.busy = true
+			return line
+		}
+
+		// Release the lock while waiting, a line with a higher
+		// priority might be queued in the meantime.
+		q.mtx.Unlock()
+		time.Sleep(delay)
+		q.mtx.Lock()
+	}
+}
+
+// done marks the line returned by the last call to pop as written.
+func (q *queue) done() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.busy = false
+	q.cond.Broadcast()
+}
+
+// drain blocks until all queued lines were written or the given
+// timeout expired. It reports whether the queue was drained.
+func (q *queue) drain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		q.mtx.Lock()
+		q.cond.Broadcast()
+		q.mtx.Unlock()
+	})
+	defer timer.Stop()
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	for q.len() > 0 || q.busy {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		q.cond.Wait()
+	}
+
+	return true
+}
+
+// next returns the highest priority with a non-empty lane. The caller
+// must hold the lock and ensure that the queue isn't empty.
+func (q *queue) next() Priority {
+	prio := numPriorities - 1
+	for prio > PriorityLow && len(q.lanes[prio]) <= 0 {
+		prio--
+	}
+
+	return prio
+}
+
+// take consumes the tokens needed for sending the given line. If not
+// enough tokens are available no tokens are consumed and the duration
+// after which enough tokens will be available is returned. If force is
+// true the tokens are always consumed, delaying subsequent lines
+// instead. The caller must hold the lock.
+func (q *queue) take(line string, force bool) time.Duration {
+	l := q.limits
+	if l.Period <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(q.last).Seconds()
+	q.last = now
+
+	period := l.Period.Seconds()
+	q.lines = math.Min(float64(l.Lines), q.lines+elapsed*float64(l.Lines)/period)
+	q.bytes = math.Min(float64(l.Bytes), q.bytes+elapsed*float64(l.Bytes)/period)
+
+	// Lines longer than the bucket would never be sent otherwise.
+	size := math.Min(float64(len(line)+2), float64(l.Bytes))
+
+	var wait float64
+	if l.Lines > 0 && q.lines < 1 {
+		wait = math.Max(wait, (1-q.lines)*period/float64(l.Lines))
+	}
+	if l.Bytes > 0 && q.bytes < size {
+		wait = math.Max(wait, (size-q.bytes)*period/float64(l.Bytes))
+	}
+
+	if wait > 0 && !force {
+		return time.Duration(wait * float64(time.Second))
+	}
+
+	if l.Lines > 0 {
+		q.lines--
+	}
+	if l.Bytes > 0 {
+		q.bytes -= size
+	}
+
+	return 0
+}
@@ -0,0 +1,169 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Tolerance for durations and tokens depending on the current time.
+const tolerance = 50 * time.Millisecond
+
+func TestQueueTake(t *testing.T) {
+	limits := Limits{Lines: 5, Bytes: 100, Period: 10 * time.Second}
+	tests := []struct {
+		name    string
+		limits  Limits
+		lines   float64
+		bytes   float64
+		elapsed time.Duration
+		line    string
+		force   bool
+		delay   time.Duration
+		left    float64
+	}{
+		{"burst", limits, 5, 100, 0, "PING", false, 0, 4},
+		{"empty", limits, 0, 100, 0, "PING", false, 2 * time.Second, 0},
+		{"refill", limits, 0, 100, time.Second, "PING", false, time.Second, 0.5},
+		{"full", limits, 0, 100, time.Minute, "PING", false, 0, 4},
+		{"bytes", limits, 5, 10, 0, "PRIVMSG #a :hello", false, 900 * time.Millisecond, 5},
+		{"long line", limits, 5, 100, 0, string(make([]byte, 200)), false, 0, 4},
+		{"forced", limits, 0, 100, 0, "PONG", true, 0, -1},
+		{"unlimited", Limits{}, 0, 0, 0, "PING", false, 0, 0},
+	}
+
+	for _, test := range tests {
+		q := newQueue(test.limits)
+		q.lines, q.bytes = test.lines, test.bytes
+		q.last = time.Now().Add(-test.elapsed)
+
+		delay := q.take(test.line, test.force)
+		if diff := delay - test.delay; diff < -tolerance || diff > tolerance {
+			t.Errorf("%s: delay = %v, want %v", test.name, delay, test.delay)
+		}
+		if math.Abs(q.lines-test.left) > 0.1 {
+			t.Errorf("%s: lines = %v, want %v", test.name, q.lines, test.left)
+		}
+	}
+}
+
+func TestQueuePriority(t *testing.T) {
+	q := newQueue(Limits{})
+	q.push(PriorityLow, "low")
+	q.push(PriorityNormal, "normal 1")
+	q.push(PriorityHigh, "high")
+	q.push(PriorityNormal, "normal 2")
+
+	var lines []string
+	for i := 0; i < 4; i++ {
+		lines = append(lines, q.pop())
+		q.done()
+	}
+
+	want := []string{"high", "normal 1", "normal 2", "low"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("lines = %q, want %q", lines, want)
+	}
+}
+
+func TestQueueEvict(t *testing.T) {
+	tests := []struct {
+		name       string
+		dropOldest bool
+		prio       Priority
+		err        error
+		lanes      [numPriorities][]string
+	}{
+		{"lower", false, PriorityNormal, nil,
+			[numPriorities][]string{nil, {"normal", "new"}, nil}},
+		{"equal", false, PriorityLow, ErrQueueFull,
+			[numPriorities][]string{{"low"}, {"normal"}, nil}},
+		{"oldest", true, PriorityLow, nil,
+			[numPriorities][]string{{"new"}, {"normal"}, nil}},
+		{"oldest normal", true, PriorityNormal, nil,
+			[numPriorities][]string{nil, {"normal", "new"}, nil}},
+		{"high", true, PriorityHigh, nil,
+			[numPriorities][]string{nil, {"normal"}, {"new"}}},
+	}
+
+	for _, test := range tests {
+		q := newQueue(Limits{QueueSize: 2, DropOldest: test.dropOldest})
+		q.push(PriorityLow, "low")
+		q.push(PriorityNormal, "normal")
+
+		if err := q.push(test.prio, "new"); err != test.err {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.err)
+		}
+		if fmt.Sprint(q.lanes) != fmt.Sprint(test.lanes) {
+			t.Errorf("%s: lanes = %q, want %q", test.name, q.lanes, test.lanes)
+		}
+	}
+}
+
+func TestQueueDrain(t *testing.T) {
+	tests := []struct {
+		name    string
+		lines   int
+		written int
+		drained bool
+		elapsed time.Duration
+	}{
+		{"empty", 0, 0, true, 0},
+		{"written", 3, 3, true, 0},
+		{"deadline", 3, 1, false, 100 * time.Millisecond},
+		{"unwritten", 1, 0, false, 100 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		q := newQueue(Limits{})
+		for i := 0; i < test.lines; i++ {
+			q.push(PriorityNormal, "PING")
+		}
+
+		go func(n int) {
+			for i := 0; i < n; i++ {
+				q.pop()
+				time.Sleep(time.Millisecond)
+				q.done()
+			}
+		}(test.written)
+
+		start := time.Now()
+		drained := q.drain(100 * time.Millisecond)
+		if drained != test.drained {
+			t.Errorf("%s: drained = %v, want %v", test.name, drained, test.drained)
+		}
+		if diff := time.Since(start) - test.elapsed; diff < -tolerance || diff > tolerance {
+			t.Errorf("%s: drain took %v, want %v", test.name, time.Since(start), test.elapsed)
+		}
+	}
+}
+
+func TestQueueClear(t *testing.T) {
+	q := newQueue(Limits{})
+	q.push(PriorityHigh, "PONG")
+	q.push(PriorityNormal, "PRIVMSG #a :hello")
+
+	q.clear()
+	if n := q.len(); n != 0 {
+		t.Fatalf("len = %d, want 0", n)
+	}
+	if !q.drain(0) {
+		t.Fatal("cleared queue not drained")
+	}
+}
@@ -0,0 +1,200 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format of timestamps in recordings.
+const recordTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// Replacement for credentials removed from recordings.
+const redacted = "<redacted>"
+
+// Direction of a recorded line.
+const (
+	Inbound  = "<"
+	Outbound = ">"
+)
+
+// Record is a single line of a recording.
+type Record struct {
+	Time      time.Time
+	Network   string
+	Direction string
+	Line      string
+}
+
+// Recorder writes timestamped inbound and outbound lines of one or
+// more clients to a writer. Each line is written as:
+//
+//	<time> <network> <direction> <line>
+type Recorder struct {
+	mtx sync.Mutex
+	w   io.Writer
+
+	// Additional strings removed from recorded lines.
+	secrets []string
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+// Redact causes the given secret, e.g. a password, to be removed from
+// all lines recorded afterwards.
+func (r *Recorder) Redact(secret string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if len(secret) > 0 {
+		r.secrets = append(r.secrets, secret)
+	}
+}
+
+// Record writes the given line using the current time. Credentials
+// are removed from the line before it is written, see redact.
+func (r *Recorder) Record(network, direction, line string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if len(network) <= 0 {
+		network = "-"
+	}
+
+	_, err := fmt.Fprintf(r.w, "%s %s %s %s\n",
+		time.Now().Format(recordTime), network, direction, r.redact(line))
+	return err
+}
+
+// Redacted returns the given line without the credentials Record
+// would remove from it.
+func (r *Recorder) Redacted(line string) string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return r.redact(line)
+}
+
+// redact removes the secrets passed to Redact, the parameters of PASS,
+// AUTHENTICATE payloads and the arguments of commands sent to services
+// like NickServ from the given line. The caller must hold the lock.
+func (r *Recorder) redact(line string) string {
+	for _, secret := range r.secrets {
+		line = strings.Replace(line, secret, redacted, -1)
+	}
+
+	// Tags, prefix and command are kept as they are.
+	var head []string
+	rest := strings.TrimLeft(line, " ")
+	for strings.HasPrefix(rest, "@") || strings.HasPrefix(rest, ":") {
+		var word string
+		word, rest = cut(rest)
+		head = append(head, word)
+	}
+
+	cmd, rest := cut(rest)
+	if len(rest) <= 0 {
+		return line
+	}
+
+	switch strings.ToUpper(cmd) {
+	case "PASS", "OPER":
+		rest = redacted
+	case "AUTHENTICATE":
+		if rest != "+" && rest != "*" && rest != SASLPlain && rest != SASLExternal {
+			rest = redacted
+		}
+	case "PRIVMSG", "NOTICE", "SQUERY":
+		target, text := cut(rest)
+		if !isService(target) {
+			return line
+		}
+
+		word, args := cut(strings.TrimPrefix(text, ":"))
+		if len(args) > 0 {
+			rest = fmt.Sprintf("%s :%s %s", target, word, redacted)
+		}
+	case "NICKSERV", "NS", "IDENTIFY":
+		word, args := cut(rest)
+		if len(args) > 0 {
+			rest = word + " " + redacted
+		}
+	default:
+		return line
+	}
+
+	return strings.Join(append(head, cmd, rest), " ")
+}
+
+// isService reports whether the given target is likely a service, e.g.
+// NickServ or NickServ@services.example.org.
+func isService(target string) bool {
+	if idx := strings.Index(target, "@"); idx >= 0 {
+		target = target[:idx]
+	}
+
+	return strings.HasSuffix(strings.ToLower(target), "serv")
+}
+
+// ParseRecord parses a single line written by a Recorder.
+func ParseRecord(line string) (Record, error) {
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) < 4 {
+		return Record{}, errors.New("malformed record")
+	}
+
+	t, err := time.Parse(recordTime, fields[0])
+	if err != nil {
+		return Record{}, err
+	}
+
+	dir := fields[2]
+	if dir != Inbound && dir != Outbound {
+		return Record{}, fmt.Errorf("invalid direction %q", dir)
+	}
+
+	return Record{t, fields[1], dir, fields[3]}, nil
+}
+
+// ReadRecords reads all records from the given reader.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var records []Record
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		record, err := ParseRecord(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+// record passes the given line to the recorder of the client, if any.
+func (c *Client) record(direction, line string) {
+	if c.Recorder != nil {
+		c.Recorder.Record(c.Network(), direction, line)
+	}
+}
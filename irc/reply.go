@@ -0,0 +1,68 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"fmt"
+)
+
+// ReplyMode determines how replies sent using Reply are delivered.
+type ReplyMode int
+
+const (
+	ReplyNotice ReplyMode = iota
+	ReplyPrivmsg
+	ReplyAction
+)
+
+// ReplyTarget returns the target replies to the given message should
+// be sent to. This is the channel for messages sent to a channel and
+// the sender for private messages.
+func (c *Client) ReplyTarget(msg Message) string {
+	if c.IsChannel(msg.Receiver) {
+		return msg.Receiver
+	}
+
+	return msg.Sender.Name
+}
+
+// Reply sends a reply to the given message using the ReplyMode of the
+// client. If ReplyPrefix is set replies sent to a channel are prefixed
+// with the nickname of the sender.
+func (c *Client) Reply(msg Message, format string, argv ...interface{}) error {
+	return c.ReplyWith(c.ReplyMode, msg, format, argv...)
+}
+
+// ReplyWith is like Reply but uses the given mode.
+func (c *Client) ReplyWith(mode ReplyMode, msg Message, format string, argv ...interface{}) error {
+	target := c.ReplyTarget(msg)
+	text := fmt.Sprintf(format, argv...)
+
+	switch mode {
+	case ReplyAction:
+		return c.Action(target, text)
+	case ReplyPrivmsg:
+		return c.Say(target, c.replyPrefix(msg, target)+text)
+	default:
+		return c.Notice(target, c.replyPrefix(msg, target)+text)
+	}
+}
+
+func (c *Client) replyPrefix(msg Message, target string) string {
+	if !c.ReplyPrefix || target == msg.Sender.Name {
+		return ""
+	}
+
+	return msg.Sender.Name + ": "
+}
@@ -0,0 +1,179 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Supported SASL mechanisms.
+const (
+	SASLPlain    = "PLAIN"
+	SASLExternal = "EXTERNAL"
+)
+
+// Maximum length of a single AUTHENTICATE payload.
+const maxAuthLen = 400
+
+// ErrSASLFailed is wrapped by the error returned by Fatal if the SASL
+// authentication configured using Authenticate failed.
+var ErrSASLFailed = errors.New("SASL authentication failed")
+
+type saslConfig struct {
+	mech     string
+	account  string
+	password string
+}
+
+// Authenticate configures the client to authenticate using SASL during
+// registration. The account and password are ignored for the EXTERNAL
+// mechanism which relies on a TLS client certificate instead. If the
+// authentication fails the connection is closed.
+func (c *Client) Authenticate(mech, account, password string) error {
+	mech = strings.ToUpper(mech)
+	if mech != SASLPlain && mech != SASLExternal {
+		return fmt.Errorf("unsupported SASL mechanism %q", mech)
+	}
+
+	c.capMtx.Lock()
+	defer c.capMtx.Unlock()
+
+	c.sasl = &saslConfig{mech, account, password}
+	c.caps.extra = append(c.caps.extra, "sasl")
+
+	return nil
+}
+
+// payload returns the base64 encoded authentication payload.
+func (s *saslConfig) payload() string {
+	if s.mech == SASLExternal {
+		return ""
+	}
+
+	data := fmt.Sprintf("%s\x00%s\x00%s", s.account, s.account, s.password)
+	return base64.StdEncoding.EncodeToString([]byte(data))
+}
+
+// supported reports whether the server supports the configured
+// mechanism according to the given value of the sasl capability.
+func (s *saslConfig) supported(value string) bool {
+	if len(value) <= 0 {
+		return true // CAP LS 301 doesn't advertise mechanisms
+	}
+
+	for _, mech := range strings.Split(value, ",") {
+		if strings.ToUpper(mech) == s.mech {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkSASL aborts the registration if SASL was configured but the
+// server doesn't support it. The caller must hold the capMtx lock.
+func (c *Client) checkSASL() error {
+	if c.sasl == nil {
+		return nil
+	}
+
+	value, ok := c.caps.available["sasl"]
+	if !ok || !c.sasl.supported(value) {
+		return c.abortSASL("server doesn't support " + c.sasl.mech)
+	}
+
+	return nil
+}
+
+// startSASL starts the SASL authentication. The caller must hold the
+// capMtx lock.
+func (c *Client) startSASL() error {
+	c.caps.pending++
+	return c.Write("AUTHENTICATE %s", c.sasl.mech)
+}
+
+// abortSASL quits the connection since registering without being
+// authenticated isn't desired if SASL was configured. Since retrying
+// with the same credentials is pointless the error is also returned
+// by Fatal. The caller must hold the capMtx lock.
+func (c *Client) abortSASL(reason string) error {
+	c.fatal = fmt.Errorf("%w: %s", ErrSASLFailed, reason)
+	c.Write("QUIT :SASL authentication failed")
+	return c.fatal
+}
+
+// Fatal returns the error which caused the client to quit if
+// reconnecting wouldn't help, e.g. failed SASL authentication.
+func (c *Client) Fatal() error {
+	c.capMtx.Lock()
+	defer c.capMtx.Unlock()
+
+	return c.fatal
+}
+
+func authenticateCmd(client *Client, msg Message) error {
+	client.capMtx.Lock()
+	defer client.capMtx.Unlock()
+
+	if client.sasl == nil || msg.Data != "+" {
+		return nil
+	}
+
+	payload := client.sasl.payload()
+	for len(payload) >= maxAuthLen {
+		if err := client.Write("AUTHENTICATE %s", payload[:maxAuthLen]); err != nil {
+			return err
+		}
+		payload = payload[maxAuthLen:]
+	}
+
+	if len(payload) <= 0 {
+		payload = "+"
+	}
+
+	return client.Write("AUTHENTICATE %s", payload)
+}
+
+func saslSuccessCmd(client *Client, msg Message) error {
+	client.capMtx.Lock()
+	defer client.capMtx.Unlock()
+
+	client.caps.authenticated = true
+	if client.caps.pending > 0 {
+		client.caps.pending--
+	}
+
+	return client.endCaps()
+}
+
+func saslFailCmd(client *Client, msg Message) error {
+	client.capMtx.Lock()
+	defer client.capMtx.Unlock()
+
+	return client.abortSASL(msg.Data)
+}
+
+func saslRegisteredCmd(client *Client, msg Message) error {
+	client.capMtx.Lock()
+	defer client.capMtx.Unlock()
+
+	if client.sasl == nil || client.caps.authenticated {
+		return nil
+	}
+
+	return client.abortSASL("registered without authentication")
+}
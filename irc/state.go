@@ -0,0 +1,459 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Member struct {
+	Nick string
+
+	// Membership prefixes ordered by rank, e.g. "@+".
+	Prefixes string
+}
+
+type Channel struct {
+	Name    string
+	Topic   string
+	Key     string
+	Modes   map[rune]string
+	Members []Member
+}
+
+type channel struct {
+	name    string
+	topic   string
+	key     string
+	modes   map[rune]string
+	members map[string]*Member
+}
+
+type state struct {
+	mtx      sync.RWMutex
+	channels map[string]*channel
+
+	// Membership modes and corresponding prefixes, e.g. "ov" and "@+".
+	prefixModes   string
+	prefixSymbols string
+
+	// Channel modes which always take a parameter, which take a
+	// parameter when set and list modes which always take one.
+	paramModes    string
+	setParamModes string
+	listModes     string
+
+	// Prefixes of channel names.
+	chanTypes string
+
+	// Casemapping used for comparing nicknames and channel names.
+	casemapping string
+
+	// Parameters advertised using RPL_ISUPPORT.
+	isupport map[string]string
+
+	// Services accounts of users in our channels, empty if the user
+	// isn't logged in. Users with an unknown account are missing.
+	accounts map[string]string
+}
+
+func newState() *state {
+	s := &state{}
+	s.reset()
+
+	return s
+}
+
+// reset forgets all channels and restores the defaults which apply
+// until the server advertises different ones.
+func (s *state) reset() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.channels = make(map[string]*channel)
+	s.isupport = make(map[string]string)
+	s.accounts = make(map[string]string)
+	for name, value := range isupportDefaults {
+		s.apply(name, value)
+	}
+}
+
+// fold returns the given name folded using the current casemapping.
+// The caller must hold the lock.
+func (s *state) fold(name string) string {
+	return foldCase(s.casemapping, name)
+}
+
+func (c *channel) export() Channel {
+	ch := Channel{
+		Name:  c.name,
+		Topic: c.topic,
+		Key:   c.key,
+		Modes: make(map[rune]string),
+	}
+
+	for mode, param := range c.modes {
+		ch.Modes[mode] = param
+	}
+	for _, member := range c.members {
+		ch.Members = append(ch.Members, *member)
+	}
+
+	sort.Slice(ch.Members, func(i, j int) bool {
+		return ch.Members[i].Nick < ch.Members[j].Nick
+	})
+
+	return ch
+}
+
+// Channels returns the names of all channels the client is in.
+func (c *Client) Channels() []string {
+	c.state.mtx.RLock()
+	defer c.state.mtx.RUnlock()
+
+	var names []string
+	for _, ch := range c.state.channels {
+		names = append(names, ch.name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Channel returns a copy of the state of the given channel.
+func (c *Client) Channel(name string) (Channel, bool) {
+	c.state.mtx.RLock()
+	defer c.state.mtx.RUnlock()
+
+	ch, ok := c.state.channels[c.state.fold(name)]
+	if !ok {
+		return Channel{}, false
+	}
+
+	return ch.export(), true
+}
+
+// IsChannel reports whether the given target is a channel name,
+// possibly prefixed with a STATUSMSG prefix.
+func (c *Client) IsChannel(target string) bool {
+	c.state.mtx.RLock()
+	defer c.state.mtx.RUnlock()
+
+	target = strings.TrimLeft(target, c.state.isupport["STATUSMSG"])
+	return len(target) > 0 && strings.IndexByte(c.state.chanTypes, target[0]) >= 0
+}
+
+// Member returns the membership of the given nick in the given channel.
+func (c *Client) Member(channel, nick string) (Member, bool) {
+	c.state.mtx.RLock()
+	defer c.state.mtx.RUnlock()
+
+	ch, ok := c.state.channels[c.state.fold(channel)]
+	if !ok {
+		return Member{}, false
+	}
+
+	member, ok := ch.members[c.state.fold(nick)]
+	if !ok {
+		return Member{}, false
+	}
+
+	return *member, true
+}
+
+// IsOp reports whether the given nick is a channel operator (or has
+// a higher rank) in the given channel.
+func (c *Client) IsOp(channel, nick string) bool {
+	return c.hasRank(channel, nick, 'o')
+}
+
+// IsVoiced reports whether the given nick is voiced (or has a higher
+// rank) in the given channel.
+func (c *Client) IsVoiced(channel, nick string) bool {
+	return c.hasRank(channel, nick, 'v')
+}
+
+func (c *Client) hasRank(channel, nick string, mode byte) bool {
+	member, ok := c.Member(channel, nick)
+	if !ok || len(member.Prefixes) <= 0 {
+		return false
+	}
+
+	c.state.mtx.RLock()
+	defer c.state.mtx.RUnlock()
+
+	rank := strings.IndexByte(c.state.prefixModes, mode)
+	if rank < 0 {
+		return false
+	}
+
+	// Prefixes are ordered by rank, the first one is the highest.
+	return strings.IndexByte(c.state.prefixSymbols, member.Prefixes[0]) <= rank
+}
+
+// addPrefix adds the given prefix symbol to the given prefixes while
+// preserving the order of the prefixes. The caller must hold the lock.
+func (s *state) addPrefix(prefixes string, symbol byte) string {
+	var b strings.Builder
+	for i := 0; i < len(s.prefixSymbols); i++ {
+		sym := s.prefixSymbols[i]
+		if sym == symbol || strings.IndexByte(prefixes, sym) >= 0 {
+			b.WriteByte(sym)
+		}
+	}
+
+	return b.String()
+}
+
+// applyModes applies the given mode changes to the given channel. The
+// caller must hold the lock.
+func (s *state) applyModes(ch *channel, changes string, params []string) {
+	adding := true
+	for _, mode := range changes {
+		switch {
+		case mode == '+' || mode == '-':
+			adding = mode == '+'
+			continue
+		case strings.ContainsRune(s.prefixModes, mode):
+			if len(params) <= 0 {
+				return
+			}
+
+			nick := params[0]
+			params = params[1:]
+
+			member, ok := ch.members[s.fold(nick)]
+			if !ok {
+				continue
+			}
+
+			symbol := s.prefixSymbols[strings.IndexRune(s.prefixModes, mode)]
+			if adding {
+				member.Prefixes = s.addPrefix(member.Prefixes, symbol)
+			} else {
+				member.Prefixes = strings.Replace(member.Prefixes, string(symbol), "", -1)
+			}
+		case strings.ContainsRune(s.listModes, mode):
+			if len(params) > 0 {
+				params = params[1:]
+			}
+		case strings.ContainsRune(s.paramModes, mode),
+			strings.ContainsRune(s.setParamModes, mode) && adding:
+			var param string
+			if len(params) > 0 {
+				param, params = params[0], params[1:]
+			}
+
+			if mode == 'k' {
+				ch.key = param
+				if !adding {
+					ch.key = ""
+				}
+			}
+
+			if adding {
+				ch.modes[mode] = param
+			} else {
+				delete(ch.modes, mode)
+			}
+		default:
+			if adding {
+				ch.modes[mode] = ""
+			} else {
+				delete(ch.modes, mode)
+			}
+		}
+	}
+}
+
+func stateJoinCmd(client *Client, msg Message) error {
+	own := client.Nick()
+
+	s := client.state
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	name := msg.Receiver
+	ch, ok := s.channels[s.fold(name)]
+	if s.fold(msg.Sender.Name) == s.fold(own) {
+		if !ok {
+			s.channels[s.fold(name)] = &channel{
+				name:    name,
+				modes:   make(map[rune]string),
+				members: make(map[string]*Member),
+			}
+		}
+	} else if ok {
+		ch.members[s.fold(msg.Sender.Name)] = &Member{Nick: msg.Sender.Name}
+	}
+
+	return nil
+}
+
+func statePartCmd(client *Client, msg Message) error {
+	client.state.removeMember(client, msg.Receiver, msg.Sender.Name)
+	return nil
+}
+
+func stateKickCmd(client *Client, msg Message) error {
+	client.state.removeMember(client, msg.Receiver, msg.Param(1))
+	return nil
+}
+
+func (s *state) removeMember(client *Client, name, nick string) {
+	own := client.Nick()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	ch, ok := s.channels[s.fold(name)]
+	if !ok {
+		return
+	}
+
+	if s.fold(nick) == s.fold(own) {
+		delete(s.channels, s.fold(name))
+		for key := range ch.members {
+			s.forget(key)
+		}
+	} else {
+		delete(ch.members, s.fold(nick))
+		s.forget(s.fold(nick))
+	}
+}
+
+// forget drops the account of the user with the given folded nick if
+// we don't share a channel anymore. The caller must hold the lock.
+func (s *state) forget(key string) {
+	for _, ch := range s.channels {
+		if _, ok := ch.members[key]; ok {
+			return
+		}
+	}
+
+	delete(s.accounts, key)
+}
+
+func stateQuitCmd(client *Client, msg Message) error {
+	s := client.state
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, ch := range s.channels {
+		delete(ch.members, s.fold(msg.Sender.Name))
+	}
+	delete(s.accounts, s.fold(msg.Sender.Name))
+
+	return nil
+}
+
+func stateNickCmd(client *Client, msg Message) error {
+	s := client.state
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	old, nick := msg.Sender.Name, msg.Receiver
+	for _, ch := range s.channels {
+		member, ok := ch.members[s.fold(old)]
+		if !ok {
+			continue
+		}
+
+		delete(ch.members, s.fold(old))
+		member.Nick = nick
+		ch.members[s.fold(nick)] = member
+	}
+
+	if account, ok := s.accounts[s.fold(old)]; ok {
+		delete(s.accounts, s.fold(old))
+		s.accounts[s.fold(nick)] = account
+	}
+
+	return nil
+}
+
+// stateNamesCmd handles RPL_NAMREPLY.
+func stateNamesCmd(client *Client, msg Message) error {
+	s := client.state
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	ch, ok := s.channels[s.fold(msg.Param(2))]
+	if !ok {
+		return nil
+	}
+
+	for _, name := range strings.Fields(msg.Param(3)) {
+		nick := strings.TrimLeft(name, s.prefixSymbols)
+		prefixes := name[:len(name)-len(nick)]
+
+		// Strip user and host sent with userhost-in-names.
+		if idx := strings.Index(nick, "!"); idx >= 0 {
+			nick = nick[:idx]
+		}
+
+		ch.members[s.fold(nick)] = &Member{nick, s.addPrefix(prefixes, 0)}
+	}
+
+	return nil
+}
+
+// stateTopicCmd handles TOPIC and RPL_TOPIC.
+func stateTopicCmd(client *Client, msg Message) error {
+	s := client.state
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	name := msg.Receiver
+	if msg.Command == "332" {
+		name = msg.Param(1)
+	}
+
+	if ch, ok := s.channels[s.fold(name)]; ok {
+		ch.topic = msg.Params[len(msg.Params)-1]
+	}
+
+	return nil
+}
+
+// stateModeCmd handles MODE and RPL_CHANNELMODEIS.
+func stateModeCmd(client *Client, msg Message) error {
+	s := client.state
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	params := msg.Params
+	if msg.Command == "324" && len(params) > 0 {
+		params = params[1:]
+	}
+
+	if len(params) < 2 {
+		return nil
+	}
+
+	ch, ok := s.channels[s.fold(params[0])]
+	if !ok {
+		return nil
+	}
+
+	if msg.Command == "324" {
+		ch.key = ""
+		ch.modes = make(map[rune]string)
+	}
+
+	s.applyModes(ch, params[1], params[2:])
+	return nil
+}
@@ -0,0 +1,98 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"crypto/tls"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// STSPolicy is an IRCv3 strict transport security policy advertised
+// using the sts capability.
+type STSPolicy struct {
+	// Port to use for secure connections, only advertised on
+	// insecure connections.
+	Port int
+
+	// Time the policy should be remembered, only advertised on
+	// secure connections. Zero means the policy should be removed.
+	Duration time.Duration
+
+	// Whether the policy may be preloaded.
+	Preload bool
+}
+
+// ParseSTS parses the value of the sts capability.
+func ParseSTS(value string) (STSPolicy, error) {
+	var policy STSPolicy
+	var hasPort, hasDuration bool
+
+	for _, param := range strings.Split(value, ",") {
+		key, val := cutByte(param, '=')
+		switch key {
+		case "port":
+			port, err := strconv.Atoi(val)
+			if err != nil || port <= 0 || port > 65535 {
+				return STSPolicy{}, errors.New("invalid STS port")
+			}
+			policy.Port, hasPort = port, true
+		case "duration":
+			secs, err := strconv.ParseUint(val, 10, 32)
+			if err != nil {
+				return STSPolicy{}, errors.New("invalid STS duration")
+			}
+			policy.Duration, hasDuration = time.Duration(secs)*time.Second, true
+		case "preload":
+			policy.Preload = true
+		}
+	}
+
+	if !hasPort && !hasDuration {
+		return STSPolicy{}, errors.New("STS policy without port and duration")
+	}
+
+	return policy, nil
+}
+
+// STS returns the STS policy advertised by the server on the current
+// or, before Setup was called, the previous connection.
+func (c *Client) STS() (STSPolicy, bool) {
+	c.capMtx.Lock()
+	defer c.capMtx.Unlock()
+
+	return c.sts()
+}
+
+// sts is like STS but the caller must hold the capMtx lock.
+func (c *Client) sts() (STSPolicy, bool) {
+	value, ok := c.caps.available["sts"]
+	if !ok {
+		return STSPolicy{}, false
+	}
+
+	policy, err := ParseSTS(value)
+	return policy, err == nil
+}
+
+// Secure reports whether the current connection uses TLS.
+func (c *Client) Secure() bool {
+	c.connMtx.Lock()
+	defer c.connMtx.Unlock()
+
+	_, ok := c.conn.(*tls.Conn)
+	return ok
+}
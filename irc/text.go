@@ -0,0 +1,234 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Maximum length of a line including the trailing CR-LF.
+	maxLineLen = 512
+
+	// Maximum length of user and host used if our own prefix is
+	// not known yet.
+	maxUserLen = 10
+	maxHostLen = 63
+
+	// Minimum amount of text sent per line.
+	minPayload = 32
+)
+
+// ErrNoMore is returned by More if no lines are buffered.
+var ErrNoMore = errors.New("no more lines buffered")
+
+type buffered struct {
+	prio  Priority
+	cmd   string
+	ctcp  string
+	lines []string
+}
+
+// Say sends the given text as a PRIVMSG to the given target, splitting
+// it into multiple lines if needed.
+func (c *Client) Say(target, text string) error {
+	return c.Send(PriorityNormal, "PRIVMSG", target, text)
+}
+
+// Notice sends the given text as a NOTICE to the given target,
+// splitting it into multiple lines if needed.
+func (c *Client) Notice(target, text string) error {
+	return c.Send(PriorityNormal, "NOTICE", target, text)
+}
+
+// Action sends the given text as a CTCP ACTION to the given target,
+// splitting it into multiple lines if needed.
+func (c *Client) Action(target, text string) error {
+	return c.send(PriorityNormal, "PRIVMSG", "ACTION", target, text)
+}
+
+// Send sends the given text to the given target using the given
+// command, e.g. PRIVMSG, and priority. Text exceeding the maximum line
+// length is split on word boundaries into multiple lines. If more than
+// MaxLines lines are needed the remaining lines are buffered and can be
+// sent using More.
+func (c *Client) Send(prio Priority, cmd, target, text string) error {
+	return c.send(prio, cmd, "", target, text)
+}
+
+// send is like Send but wraps each line in a CTCP message with the
+// given command if it isn't empty.
+func (c *Client) send(prio Priority, cmd, ctcp, target, text string) error {
+	limit := c.payloadLen(cmd, target)
+	if len(ctcp) > 0 {
+		limit -= len(ctcp) + 3 // \x01<ctcp> <text>\x01
+	}
+	lines := splitText(sanitize(text), limit)
+	key := c.Fold(target)
+
+	c.moreMtx.Lock()
+	delete(c.more, key)
+	if c.MaxLines > 0 && len(lines) > c.MaxLines {
+		c.more[key] = &buffered{prio, cmd, ctcp, lines[c.MaxLines:]}
+		lines = lines[:c.MaxLines]
+	}
+	c.moreMtx.Unlock()
+
+	return c.sendLines(prio, cmd, ctcp, target, lines)
+}
+
+// More sends the next lines buffered for the given target by Send.
+func (c *Client) More(target string) error {
+	key := c.Fold(target)
+
+	c.moreMtx.Lock()
+	buf, ok := c.more[key]
+	if !ok {
+		c.moreMtx.Unlock()
+		return ErrNoMore
+	}
+
+	lines := buf.lines
+	if c.MaxLines > 0 && len(lines) > c.MaxLines {
+		buf.lines = lines[c.MaxLines:]
+		lines = lines[:c.MaxLines]
+	} else {
+		delete(c.more, key)
+	}
+	c.moreMtx.Unlock()
+
+	return c.sendLines(buf.prio, buf.cmd, buf.ctcp, target, lines)
+}
+
+func (c *Client) sendLines(prio Priority, cmd, ctcp, target string, lines []string) error {
+	for _, line := range lines {
+		err := c.writeText(prio, cmd, ctcp, target, line)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.moreMtx.Lock()
+	buf, ok := c.more[c.Fold(target)]
+	c.moreMtx.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	hint := c.MoreCommand
+	if c.MoreHint != nil {
+		hint = c.MoreHint(target)
+	}
+
+	more := fmt.Sprintf("(%d more lines, use %s)", len(buf.lines), hint)
+	return c.writeText(prio, cmd, "", target, more)
+}
+
+// writeText queues a single line of sanitized text. Since sanitize
+// removes the CTCP delimiters they are added afterwards.
+func (c *Client) writeText(prio Priority, cmd, ctcp, target, text string) error {
+	text = sanitize(text)
+	if len(ctcp) > 0 && len(text) > 0 {
+		text = fmt.Sprintf("%s%s %s%s", ctcpDelim, sanitize(ctcp), text, ctcpDelim)
+	} else if len(ctcp) > 0 {
+		text = ctcpDelim + sanitize(ctcp) + ctcpDelim
+	}
+
+	return c.queue.push(prio, fmt.Sprintf("%s %s :%s",
+		sanitize(cmd), sanitize(target), text))
+}
+
+// payloadLen returns the maximum length of text which can be sent to
+// the given target using the given command without exceeding the
+// maximum line length when relayed by the server.
+func (c *Client) payloadLen(cmd, target string) int {
+	c.prefixMtx.Lock()
+	user, host := c.user, c.host
+	c.prefixMtx.Unlock()
+
+	if len(user) <= 0 {
+		user = strings.Repeat("x", maxUserLen)
+	}
+	if len(host) <= 0 {
+		host = strings.Repeat("x", maxHostLen)
+	}
+
+	// :<nick>!<user>@<host> <cmd> <target> :<text>\r\n
+	prefix := len(c.Nick()) + len(user) + len(host) + 2
+	overhead := prefix + len(cmd) + len(target) + 7
+	if n := maxLineLen - overhead; n > minPayload {
+		return n
+	}
+
+	return minPayload
+}
+
+// prefixCmd learns our own user and host from JOIN messages sent by us.
+func prefixCmd(client *Client, msg Message) error {
+	s := msg.Sender
+	if !client.EqualFold(s.Name, client.Nick()) || len(s.User) <= 0 || len(s.Hostname) <= 0 {
+		return nil
+	}
+
+	client.prefixMtx.Lock()
+	client.user, client.host = s.User, s.Hostname
+	client.prefixMtx.Unlock()
+
+	return nil
+}
+
+// hostCmd learns our own host from RPL_HOSTHIDDEN.
+func hostCmd(client *Client, msg Message) error {
+	client.prefixMtx.Lock()
+	client.host = msg.Param(1)
+	client.prefixMtx.Unlock()
+
+	return nil
+}
+
+// splitText splits the given text into lines with a length of at most
+// limit bytes. The text is split on spaces if possible and never
+// inside a UTF-8 sequence.
+func splitText(text string, limit int) []string {
+	var lines []string
+	for len(text) > limit {
+		idx := strings.LastIndex(text[:limit+1], " ")
+		if idx > 0 {
+			lines = append(lines, text[:idx])
+			text = text[idx+1:]
+			continue
+		}
+
+		idx = limit
+		for idx > 0 && !utf8.RuneStart(text[idx]) {
+			idx--
+		}
+		if idx <= 0 {
+			_, idx = utf8.DecodeRuneInString(text)
+		}
+
+		lines = append(lines, text[:idx])
+		text = text[idx:]
+	}
+
+	if len(text) <= 0 && len(lines) > 0 {
+		return lines
+	}
+
+	return append(lines, text)
+}
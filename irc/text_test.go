@@ -0,0 +1,43 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		text  string
+		limit int
+		want  []string
+	}{
+		{"", 10, []string{""}},
+		{"short", 10, []string{"short"}},
+		{"hello world foo", 11, []string{"hello world", "foo"}},
+		{"hello world", 5, []string{"hello", "world"}},
+		{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"äöü", 3, []string{"ä", "ö", "ü"}},
+		{"äöü", 1, []string{"ä", "ö", "ü"}},
+	}
+
+	for _, test := range tests {
+		lines := splitText(test.text, test.limit)
+		if !reflect.DeepEqual(lines, test.want) {
+			t.Errorf("splitText(%q, %d) = %q, want %q",
+				test.text, test.limit, lines, test.want)
+		}
+	}
+}
@@ -15,62 +15,303 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
-	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/nmeum/marvin/irc"
 	"github.com/nmeum/marvin/modules"
-	"io/ioutil"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
 const (
 	appName = "marvin"
+
+	// Bounds for the delay between reconnection attempts.
+	minBackoff = 1 * time.Second
+	maxBackoff = 5 * time.Minute
+
+	// Time to wait for modules and the send queue on shutdown.
+	shutdownTimeout = 10 * time.Second
 )
 
 var (
 	conf = flag.String("c", "marvin.json", "configuration file")
 	verb = flag.Bool("v", false, "verbose output")
+
+	record = flag.String("r", "", "record traffic to file")
+	replay = flag.String("p", "", "replay traffic recorded to file")
 )
 
+var replyModes = map[string]irc.ReplyMode{
+	"notice":  irc.ReplyNotice,
+	"privmsg": irc.ReplyPrivmsg,
+	"action":  irc.ReplyAction,
+}
+
+type bot struct {
+	conf    config
+	conn    net.Conn
+	client  *irc.Client
+	modules *modules.ModuleSet
+	logger  *log.Logger
+	sts     *stsStore
+	sasl    bool
+	backoff time.Duration
+
+	// Closed once the shutdown completed.
+	done     chan bool
+	quitOnce sync.Once
+
+	// Channels joined before the connection was lost and the keys
+	// of these channels by folded name. Both are reset once the
+	// channels were joined again.
+	rejoinMtx sync.Mutex
+	rejoin    []string
+	keys      map[string]string
+}
+
 func main() {
 	flag.Parse()
 	logger := log.New(os.Stderr, "ERROR: ", 0)
 
-	config, err := readConfig(*conf)
+	networks, err := readConfig(*conf)
 	if err != nil && !os.IsNotExist(err) {
 		logger.Fatal(err)
 	}
 
-	conn, err := connect(config)
+	if len(*replay) > 0 {
+		if err := replayFile(*replay, networks, os.Stdout); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+
+	var recorder *irc.Recorder
+	if len(*record) > 0 {
+		file, err := os.OpenFile(*record, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		defer file.Close()
+
+		recorder = irc.NewRecorder(file)
+	}
+
+	group := irc.NewGroup()
+	var bots []*bot
+	for _, config := range networks {
+		sts, err := loadSTS(config.STSFile)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		// Invalid configurations aren't fixed by reconnecting.
+		if _, err := newDialer(config); err != nil {
+			logger.Fatal(err)
+		}
+
+		// If the network isn't reachable loop keeps trying.
+		conn, err := connect(config, sts)
+		if err != nil {
+			logger.Println(err)
+			conn = nil
+		}
+
+		b, err := setup(conn, config, group, recorder)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		b.sts = sts
+
+		// Only mention the network if there are several ones.
+		if len(networks) > 1 {
+			b.logger = log.New(os.Stderr, fmt.Sprintf("ERROR: %s: ", config.Network), 0)
+		}
+
+		bots = append(bots, b)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go shutdown(bots, signals)
+
+	for _, b := range bots {
+		go b.loop()
+	}
+	for _, b := range bots {
+		<-b.done
+	}
+}
+
+func setup(conn net.Conn, config config, group *irc.Group, recorder *irc.Recorder) (*bot, error) {
+	client := irc.NewClient(conn)
+	client.Recorder = recorder
+	if recorder != nil {
+		recorder.Redact(config.SASLPass)
+	}
+	group.Add(config.Network, client)
+
+	b := &bot{
+		conf:    config,
+		conn:    conn,
+		client:  client,
+		logger:  log.New(os.Stderr, "ERROR: ", 0),
+		backoff: minBackoff,
+		done:    make(chan bool),
+	}
+
+	period, err := time.ParseDuration(config.FloodPeriod)
 	if err != nil {
-		logger.Fatal(err)
+		return nil, err
+	}
+
+	client.SetLimits(irc.Limits{
+		Lines:      config.FloodLines,
+		Bytes:      config.FloodBytes,
+		Period:     period,
+		QueueSize:  config.QueueSize,
+		DropOldest: config.DropOldest,
+	})
+	client.MaxLines = config.MaxLines
+	client.AltNicks = config.AltNicks
+
+	mode, ok := replyModes[strings.ToLower(config.ReplyMode)]
+	if !ok {
+		return nil, fmt.Errorf("unknown reply mode %q", config.ReplyMode)
+	}
+	client.ReplyMode = mode
+	client.ReplyPrefix = config.ReplyPrefix
+
+	if client.Charset, err = charset(config.Charset); err != nil {
+		return nil, err
+	}
+
+	client.ChannelCharsets = make(map[string]encoding.Encoding)
+	for channel, name := range config.ChannelCharsets {
+		if client.ChannelCharsets[channel], err = charset(name); err != nil {
+			return nil, err
+		}
+	}
+
+	client.Version = config.CTCPVersion
+	client.CTCPReplies = nil
+	for _, cmd := range config.CTCPReplies {
+		client.CTCPReplies = append(client.CTCPReplies, strings.ToUpper(cmd))
+	}
+
+	if client.PingInterval, err = time.ParseDuration(config.PingInterval); err != nil {
+		return nil, err
+	}
+	if client.PingTimeout, err = time.ParseDuration(config.PingTimeout); err != nil {
+		return nil, err
+	}
+
+	mech := config.SASLMech
+	if len(mech) <= 0 && len(config.SASLPass) >= 1 {
+		mech = irc.SASLPlain
+	}
+
+	if len(mech) >= 1 {
+		if strings.ToUpper(mech) == irc.SASLExternal && len(config.ClientCert) <= 0 {
+			return nil, errors.New("SASL EXTERNAL requires a client certificate")
+		}
+
+		if err := client.Authenticate(mech, config.SASLUser, config.SASLPass); err != nil {
+			return nil, err
+		}
+		b.sasl = true
+	}
+	client.CmdHook("001", b.joinCmd)
+	client.CmdHook("cap", b.stsCmd)
+
+	b.modules = modules.NewModuleSet(client, config.Conf)
+	b.modules.Prefixes = config.CommandPrefixes
+	b.modules.ChannelPrefixes = config.ChannelPrefixes
+	b.modules.NickCommands = config.NickCommands
+	for _, fn := range moduleInits {
+		fn(b.modules)
+	}
+
+	if len(config.Modules) > 0 {
+		if err := b.modules.Select(config.Modules); err != nil {
+			return nil, err
+		}
 	}
-	defer conn.Close()
+	b.modules.Exclude(config.exclude)
+
+	client.Setup(config.Nick, config.Name, config.Host)
+	return b, b.modules.LoadAll()
+}
 
+// loop runs the bot and reconnects whenever the connection is lost
+// until the client quits.
+func (b *bot) loop() {
 	errChan := make(chan error)
 	go func() {
 		for err := range errChan {
-			logger.Println(err)
+			b.logger.Println(err)
 		}
 	}()
 
-	ircBot, err := setup(conn, config)
-	if err != nil {
-		logger.Fatal(err)
+	for {
+		if b.conn == nil {
+			if b.conn = b.reconnect(); b.conn == nil {
+				return
+			}
+		}
+
+		start := time.Now()
+		err := b.run(b.conn, errChan)
+		b.conn.Close()
+
+		if b.client.Context().Err() != nil {
+			return
+		}
+		b.logger.Println(err)
+
+		// Reconnecting can't fix e.g. wrong SASL credentials.
+		if err := b.client.Fatal(); err != nil {
+			b.logger.Printf("giving up on %s: %s", b.conf.Network, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			b.quit(ctx)
+			cancel()
+			return
+		}
+
+		// Reconnect using TLS if requested by an STS policy.
+		if policy, ok := b.client.STS(); ok && policy.Port > 0 && !b.client.Secure() {
+			b.sts.upgrade(b.conf.Host, policy.Port)
+			b.backoff = minBackoff
+		}
+
+		if time.Since(start) >= maxBackoff {
+			b.backoff = minBackoff
+		}
+		b.conn = nil
 	}
+}
 
+// run reads from the given connection and passes each line to the
+// client until reading fails.
+func (b *bot) run(conn net.Conn, errChan chan error) error {
 	reader := bufio.NewReader(conn)
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil {
-			logger.Println(err)
-			break
+			return err
 		}
 
 		line = strings.Trim(line, "\n")
@@ -80,50 +321,196 @@ func main() {
 			fmt.Println(line)
 		}
 
-		ircBot.Handle(line, errChan)
+		b.client.Handle(line, errChan)
 	}
 }
 
-func setup(conn net.Conn, config config) (client *irc.Client, err error) {
-	client = irc.NewClient(conn)
-	client.CmdHook("001", func(c *irc.Client, m irc.Message) error {
-		time.Sleep(3 * time.Second) // Wait for NickServ etc
-		return c.Write("JOIN %s", strings.Join(config.Chan, ","))
-	})
+// reconnect establishes a new connection using exponential backoff
+// and registers the client again. Modules stay loaded. If the client
+// quits in the meantime nil is returned.
+func (b *bot) reconnect() net.Conn {
+	// Keep the previous channels if the connection was lost before
+	// they were joined again.
+	b.rejoinMtx.Lock()
+	if b.rejoin == nil {
+		b.rejoin = b.client.Channels()
+		b.keys = make(map[string]string)
+		for _, name := range b.rejoin {
+			if ch, ok := b.client.Channel(name); ok && len(ch.Key) > 0 {
+				b.keys[b.client.Fold(name)] = ch.Key
+			}
+		}
+	}
+	b.rejoinMtx.Unlock()
 
-	moduleSet := modules.NewModuleSet(client, config.Conf)
-	for _, fn := range moduleInits {
-		fn(moduleSet)
+	for {
+		select {
+		case <-time.After(b.backoff):
+		case <-b.client.Context().Done():
+			return nil
+		}
+
+		if b.backoff *= 2; b.backoff > maxBackoff {
+			b.backoff = maxBackoff
+		}
+
+		conn, err := connect(b.conf, b.sts)
+		if err != nil {
+			b.logger.Println(err)
+			continue
+		}
+
+		b.client.Reconnect(conn)
+		b.client.Setup(b.conf.Nick, b.conf.Name, b.conf.Host)
+		return conn
 	}
+}
 
-	client.Setup(config.Nick, config.Name, config.Host)
-	return client, moduleSet.LoadAll()
+// shutdown waits for a signal and shuts down all bots concurrently.
+func shutdown(bots []*bot, signals chan os.Signal) {
+	<-signals
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	for _, b := range bots {
+		go b.quit(ctx)
+	}
+	for _, b := range bots {
+		<-b.done
+	}
 }
 
-func connect(config config) (conn net.Conn, err error) {
-	netw := "tcp"
-	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
+// quit unloads all modules and quits before the given context expires.
+// Only the first call has an effect.
+func (b *bot) quit(ctx context.Context) {
+	b.quitOnce.Do(func() { b.doQuit(ctx) })
+}
 
-	if len(config.Cert) >= 1 {
-		certFile, err := ioutil.ReadFile(config.Cert)
-		if err != nil {
-			return nil, err
+func (b *bot) doQuit(ctx context.Context) {
+	defer close(b.done)
+
+	if err := b.modules.UnloadAll(ctx); err != nil {
+		b.logger.Println(err)
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := b.client.Quit(b.conf.QuitMsg, time.Until(deadline)); err != nil {
+		b.logger.Println(err)
+	}
+}
+
+func (b *bot) joinCmd(client *irc.Client, msg irc.Message) error {
+	if !b.sasl {
+		time.Sleep(3 * time.Second) // Wait for NickServ etc
+	}
+
+	b.rejoinMtx.Lock()
+	defer b.rejoinMtx.Unlock()
+
+	channels := b.conf.Chan
+	for _, ch := range b.rejoin {
+		if !containsFold(client, channels, ch) {
+			channels = append(channels, ch)
 		}
+	}
 
-		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(certFile)
+	// Channels with a key have to be listed first.
+	var keyed, keys, unkeyed []string
+	for _, ch := range channels {
+		if key, ok := b.keys[client.Fold(ch)]; ok {
+			keyed, keys = append(keyed, ch), append(keys, key)
+		} else {
+			unkeyed = append(unkeyed, ch)
+		}
+	}
+	b.rejoin, b.keys = nil, nil
 
-		tlsConfig := &tls.Config{RootCAs: caCertPool}
-		if len(config.ClientCert) >= 1 && len(config.ClientKey) >= 1 {
-			clientCert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
-			if err != nil {
-				return nil, err
-			}
+	if len(channels) <= 0 {
+		return nil
+	} else if len(keys) <= 0 {
+		return client.Write("JOIN %s", strings.Join(unkeyed, ","))
+	}
 
-			tlsConfig.Certificates = []tls.Certificate{ clientCert }
+	return client.Write("JOIN %s %s", strings.Join(append(keyed, unkeyed...), ","),
+		strings.Join(keys, ","))
+}
+
+// charset returns the encoding with the given name, nil for UTF-8.
+func charset(name string) (encoding.Encoding, error) {
+	if len(name) <= 0 || strings.EqualFold(name, "utf-8") || strings.EqualFold(name, "utf8") {
+		return nil, nil
+	}
+
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown charset %q", name)
+	}
+
+	return enc, nil
+}
+
+// containsFold reports whether the given slice contains the given
+// element using the casemapping of the given client.
+func containsFold(client *irc.Client, slice []string, element string) bool {
+	for _, e := range slice {
+		if client.EqualFold(e, element) {
+			return true
 		}
-		return tls.Dial(netw, addr, tlsConfig)
 	}
 
-	return net.Dial(netw, addr)
+	return false
+}
+
+// stsCmd stores STS policies advertised on secure connections.
+func (b *bot) stsCmd(client *irc.Client, msg irc.Message) error {
+	sub := strings.ToUpper(msg.Param(1))
+	if b.sts == nil || !client.Secure() || (sub != "LS" && sub != "NEW") {
+		return nil
+	}
+
+	policy, ok := client.STS()
+	if !ok {
+		return nil
+	}
+
+	port, _ := b.sts.endpoint(b.conf)
+	return b.sts.update(b.conf.Host, port, policy.Duration)
+}
+
+func connect(config config, sts *stsStore) (net.Conn, error) {
+	port, secure := sts.endpoint(config)
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(port))
+
+	d, err := newDialer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// The timeout includes the proxy and TLS handshakes.
+	ctx := context.Background()
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+
+	conn, err := d.open(ctx, addr)
+	if err != nil || !secure {
+		return conn, err
+	}
+
+	tlsConfig, err := tlsConfig(config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
 }
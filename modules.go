@@ -16,6 +16,7 @@ package main
 import (
 	"github.com/nmeum/marvin/modules"
 	"github.com/nmeum/marvin/modules/feed"
+	"github.com/nmeum/marvin/modules/lag"
 	"github.com/nmeum/marvin/modules/nickserv"
 	"github.com/nmeum/marvin/modules/rejoin"
 	"github.com/nmeum/marvin/modules/remind"
@@ -26,6 +27,10 @@ import (
 
 type moduleInit func(*modules.ModuleSet)
 
+// Modules contacting other services, e.g. by fetching URLs. They are
+// not loaded when replaying recorded traffic.
+var onlineModules = []string{"twitter", "feed", "url", "spacestatus"}
+
 var moduleInits = []moduleInit{
 	nickserv.Init,
 	twitter.Init,
@@ -34,4 +39,5 @@ var moduleInits = []moduleInit{
 	time.Init,
 	feed.Init,
 	url.Init,
+	lag.Init,
 }
@@ -0,0 +1,351 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nmeum/marvin/irc"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scope restricts where a command can be used.
+type Scope int
+
+const (
+	ScopeAny Scope = iota
+	ScopeChannel
+	ScopePrivate
+)
+
+// Level is the permission level required for running a command.
+type Level int
+
+const (
+	// Anyone can run the command.
+	LevelAnyone Level = iota
+
+	// The sender must share a channel with the client.
+	LevelMember
+
+	// The sender must be voiced or an operator in the channel the
+	// command was sent to or, in private messages, in any channel
+	// shared with the client.
+	LevelVoice
+	LevelOp
+)
+
+// ArgType describes how an argument of a command is parsed.
+type ArgType int
+
+const (
+	// A single word.
+	ArgString ArgType = iota
+
+	// A single word parsed as an integer or as a duration.
+	ArgInt
+	ArgDuration
+
+	// All remaining words, only allowed as the last argument.
+	ArgRest
+)
+
+// Arg describes an argument of a command.
+type Arg struct {
+	// Name of the argument shown in the usage in upper case.
+	Name string
+
+	Type     ArgType
+	Optional bool
+}
+
+// Args contains the parsed arguments of a command by name.
+type Args map[string]interface{}
+
+// String returns the given string or rest-of-line argument.
+func (a Args) String(name string) string {
+	s, _ := a[name].(string)
+	return s
+}
+
+// Int returns the given integer argument.
+func (a Args) Int(name string) int64 {
+	n, _ := a[name].(int64)
+	return n
+}
+
+// Duration returns the given duration argument.
+func (a Args) Duration(name string) time.Duration {
+	d, _ := a[name].(time.Duration)
+	return d
+}
+
+// Has reports whether the given optional argument was passed.
+func (a Args) Has(name string) bool {
+	_, ok := a[name]
+	return ok
+}
+
+// Command describes a command handled by the ModuleSet.
+type Command struct {
+	Name    string
+	Aliases []string
+	Args    []Arg
+
+	// Short description of the command shown by !help.
+	Help string
+
+	Level Level
+	Scope Scope
+
+	// Function run with the parsed arguments.
+	Run func(*irc.Client, irc.Message, Args) error
+}
+
+// Commander is implemented by modules providing commands. Commands
+// is called after the module was loaded successfully.
+type Commander interface {
+	Commands() []Command
+}
+
+// errUsage is returned by parse if the amount of arguments is wrong.
+var errUsage = errors.New("wrong amount of arguments")
+
+// Usage returns a usage string generated from the arguments of the
+// command using the given prefix, e.g. "!remind DURATION MESSAGE...".
+func (c *Command) Usage(prefix string) string {
+	usage := prefix + c.Name
+	for _, arg := range c.Args {
+		name := strings.ToUpper(arg.Name)
+		if arg.Type == ArgRest {
+			name += "..."
+		}
+		if arg.Optional {
+			name = "[" + name + "]"
+		}
+
+		usage += " " + name
+	}
+
+	return usage
+}
+
+// parse parses the given words according to the arguments of the
+// command.
+func (c *Command) parse(words []string) (Args, error) {
+	args := make(Args)
+	for _, arg := range c.Args {
+		if len(words) <= 0 {
+			if arg.Optional {
+				break
+			}
+			return nil, errUsage
+		}
+
+		word := words[0]
+		words = words[1:]
+
+		switch arg.Type {
+		case ArgString:
+			args[arg.Name] = word
+		case ArgInt:
+			n, err := strconv.ParseInt(word, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a number", strings.ToUpper(arg.Name))
+			}
+			args[arg.Name] = n
+		case ArgDuration:
+			d, err := time.ParseDuration(word)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a duration, e.g. 10m", strings.ToUpper(arg.Name))
+			}
+			args[arg.Name] = d
+		case ArgRest:
+			args[arg.Name] = strings.Join(append([]string{word}, words...), " ")
+			words = nil
+		}
+	}
+
+	if len(words) > 0 {
+		return nil, errUsage
+	}
+
+	return args, nil
+}
+
+// permitted reports whether the sender of the given message has the
+// permission level required for running the command.
+func (c *Command) permitted(client *irc.Client, msg irc.Message) bool {
+	if c.Level <= LevelAnyone {
+		return true
+	}
+
+	channels := client.Channels()
+	if client.IsChannel(msg.Receiver) {
+		channels = []string{msg.Receiver}
+	}
+
+	for _, ch := range channels {
+		switch c.Level {
+		case LevelMember:
+			if _, ok := client.Member(ch, msg.Sender.Name); ok {
+				return true
+			}
+		case LevelVoice:
+			if client.IsVoiced(ch, msg.Sender.Name) {
+				return true
+			}
+		case LevelOp:
+			if client.IsOp(ch, msg.Sender.Name) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+type registered struct {
+	module  string
+	command Command
+}
+
+// addCommand registers the given command provided by the module with
+// the given name, which is empty for commands of the ModuleSet.
+func (m *ModuleSet) addCommand(module string, cmd Command) error {
+	names := append([]string{cmd.Name}, cmd.Aliases...)
+	for _, name := range names {
+		if _, ok := m.commands[strings.ToLower(name)]; ok {
+			return fmt.Errorf("command %q of module %q is already registered", name, module)
+		}
+	}
+
+	r := &registered{module, cmd}
+	for _, name := range names {
+		m.commands[strings.ToLower(name)] = r
+	}
+	m.ordered = append(m.ordered, r)
+
+	return nil
+}
+
+// findCommand returns the command registered with the given name or
+// alias, if any.
+func (m *ModuleSet) findCommand(name string) *Command {
+	r, ok := m.commands[strings.ToLower(name)]
+	if !ok {
+		return nil
+	}
+
+	return &r.command
+}
+
+// prefixes returns the command prefixes used for the given target.
+func (m *ModuleSet) prefixes(client *irc.Client, target string) []string {
+	if client.IsChannel(target) {
+		for name, prefixes := range m.ChannelPrefixes {
+			if client.EqualFold(name, target) {
+				return prefixes
+			}
+		}
+	}
+
+	return m.Prefixes
+}
+
+// prefix returns the command prefix mentioned in replies sent to the
+// given target.
+func (m *ModuleSet) prefix(client *irc.Client, target string) string {
+	if prefixes := m.prefixes(client, target); len(prefixes) > 0 {
+		return prefixes[0]
+	}
+
+	return client.Nick() + ": "
+}
+
+// lookup returns the command the given word refers to if it starts
+// with one of the given prefixes, or if optional is true, without one.
+func (m *ModuleSet) lookup(word string, prefixes []string, optional bool) *Command {
+	for _, prefix := range prefixes {
+		if len(prefix) <= 0 || !strings.HasPrefix(word, prefix) {
+			continue
+		}
+
+		if cmd := m.findCommand(word[len(prefix):]); cmd != nil {
+			return cmd
+		}
+	}
+
+	if optional {
+		return m.findCommand(word)
+	}
+
+	return nil
+}
+
+// parseCommand returns the command contained in the given message and
+// its arguments, if any. Commands start with one of the prefixes used
+// for the target of the message or, if NickCommands is set, with the
+// nickname of the client followed by a colon or comma.
+func (m *ModuleSet) parseCommand(client *irc.Client, msg irc.Message) (*Command, []string) {
+	words := strings.Fields(msg.Data)
+	if len(words) <= 0 {
+		return nil, nil
+	}
+
+	prefixes := m.prefixes(client, msg.Receiver)
+	if m.NickCommands && len(words) > 1 {
+		nick := strings.TrimRight(words[0], ":,")
+		if len(nick) < len(words[0]) && client.EqualFold(nick, client.Nick()) {
+			return m.lookup(words[1], prefixes, true), words[2:]
+		}
+	}
+
+	return m.lookup(words[0], prefixes, false), words[1:]
+}
+
+// commandCmd runs the command contained in the given message.
+func (m *ModuleSet) commandCmd(client *irc.Client, msg irc.Message) error {
+	if client.EqualFold(msg.Sender.Name, client.Nick()) {
+		return nil // Sent by us using echo-message
+	}
+
+	cmd, words := m.parseCommand(client, msg)
+	if cmd == nil {
+		return nil
+	}
+
+	prefix := m.prefix(client, msg.Receiver)
+	switch channel := client.IsChannel(msg.Receiver); {
+	case cmd.Scope == ScopeChannel && !channel:
+		return client.Reply(msg, "%s%s can only be used in channels", prefix, cmd.Name)
+	case cmd.Scope == ScopePrivate && channel:
+		return client.Reply(msg, "%s%s can only be used in private messages", prefix, cmd.Name)
+	}
+
+	if !cmd.permitted(client, msg) {
+		return client.Reply(msg, "You aren't allowed to use %s%s", prefix, cmd.Name)
+	}
+
+	args, err := cmd.parse(words)
+	if err == errUsage {
+		return client.Reply(msg, "USAGE: %s", cmd.Usage(prefix))
+	} else if err != nil {
+		return client.Reply(msg, "ERROR: %s, USAGE: %s", err, cmd.Usage(prefix))
+	}
+
+	return cmd.Run(client, msg, args)
+}
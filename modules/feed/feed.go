@@ -14,8 +14,10 @@
 package feed
 
 import (
+	"fmt"
 	"github.com/nmeum/go-feedparser"
 	"github.com/nmeum/marvin/irc"
+	"github.com/nmeum/marvin/irc/format"
 	"github.com/nmeum/marvin/modules"
 	"html"
 	"net/http"
@@ -33,6 +35,7 @@ type Module struct {
 	feeds    map[string]time.Time
 	URLs     []string `json:"urls"`
 	Interval string   `json:"interval"`
+	format.Style
 }
 
 func Init(moduleSet *modules.ModuleSet) {
@@ -70,9 +73,14 @@ func (m *Module) Load(client *irc.Client) error {
 	}()
 
 	go func() {
+		defer close(newPosts)
 		for {
-			time.Sleep(duration)
-			m.pollFeeds(newPosts)
+			select {
+			case <-time.After(duration):
+				m.pollFeeds(newPosts)
+			case <-client.Context().Done():
+				return
+			}
 		}
 	}()
 
@@ -86,7 +94,7 @@ func (m *Module) pollFeeds(out chan post) {
 		go func(u string) {
 			defer wg.Done()
 			feed, err := m.fetchFeed(u)
-			if err != nil {
+			if err != nil || len(feed.Items) <= 0 {
 				return
 			}
 
@@ -125,9 +133,12 @@ func (m *Module) fetchFeed(url string) (feed feedparser.Feed, err error) {
 
 func (m *Module) notify(client *irc.Client, post post) {
 	ftitle := html.UnescapeString(post.Feed.Title)
-	for _, ch := range client.Channels {
-		ititle := html.UnescapeString(post.Item.Title)
-		client.Write("NOTICE %s :%s -- %s new entry %s: %s",
-			ch, strings.ToUpper(m.Name()), ftitle, ititle, post.Item.Link)
+	ititle := html.UnescapeString(post.Item.Title)
+
+	text := m.Style.Apply(fmt.Sprintf("%s -- %s new entry %s: %s",
+		strings.ToUpper(m.Name()), format.Bold(ftitle), ititle, post.Item.Link))
+
+	for _, ch := range client.Channels() {
+		client.Send(irc.PriorityLow, "NOTICE", ch, text)
 	}
 }
@@ -0,0 +1,55 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lag
+
+import (
+	"github.com/nmeum/marvin/irc"
+	"github.com/nmeum/marvin/modules"
+	"time"
+)
+
+type Module struct{}
+
+func Init(moduleSet *modules.ModuleSet) {
+	moduleSet.Register(new(Module))
+}
+
+func (m *Module) Name() string {
+	return "lag"
+}
+
+func (m *Module) Help() string {
+	return "Displays the lag to the server."
+}
+
+func (m *Module) Defaults() {}
+
+func (m *Module) Load(client *irc.Client) error {
+	return nil
+}
+
+func (m *Module) Commands() []modules.Command {
+	return []modules.Command{
+		{Name: "lag", Help: "Shows the round-trip time to the server", Run: m.lagCmd},
+	}
+}
+
+func (m *Module) lagCmd(client *irc.Client, msg irc.Message, args modules.Args) error {
+	lag := client.Lag()
+	if lag <= 0 {
+		return client.Reply(msg, "Lag hasn't been measured yet.")
+	}
+
+	return client.Reply(msg, "Current lag: %s", lag.Round(time.Millisecond))
+}
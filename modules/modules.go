@@ -14,6 +14,7 @@
 package modules
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/nmeum/marvin/irc"
@@ -30,20 +31,91 @@ type Module interface {
 	Defaults()
 }
 
+// Unloader is implemented by modules which need to release resources
+// or persist state on shutdown. The given context expires when the
+// shutdown is no longer awaited.
+type Unloader interface {
+	Unload(ctx context.Context) error
+}
+
 type ModuleSet struct {
 	client  *irc.Client
 	modules []Module
 	configs string
+
+	// Registered commands by name and alias and in order of
+	// registration.
+	commands map[string]*registered
+	ordered  []*registered
+
+	// Prefixes of commands, e.g. "!", and prefixes used in specific
+	// channels instead. If NickCommands is set commands can also be
+	// addressed to the client, e.g. "marvin: help".
+	Prefixes        []string
+	ChannelPrefixes map[string][]string
+	NickCommands    bool
 }
 
 func NewModuleSet(client *irc.Client, configs string) *ModuleSet {
-	return &ModuleSet{client: client, configs: configs}
+	m := &ModuleSet{
+		client:   client,
+		configs:  configs,
+		commands: make(map[string]*registered),
+
+		Prefixes:     []string{"!"},
+		NickCommands: true,
+	}
+
+	for _, cmd := range m.builtins() {
+		m.addCommand("", cmd)
+	}
+	client.CmdHook("privmsg", m.commandCmd)
+	client.MoreHint = func(target string) string {
+		return m.prefix(client, target) + "more"
+	}
+
+	return m
 }
 
 func (m *ModuleSet) Register(module Module) {
 	m.modules = append(m.modules, module)
 }
 
+// Select removes all registered modules except for the ones with the
+// given names. It fails if one of the names doesn't refer to a
+// registered module.
+func (m *ModuleSet) Select(names []string) error {
+	var selected []Module
+	for _, name := range names {
+		module := m.findModule(strings.ToLower(name))
+		if module == nil {
+			return fmt.Errorf("module %q isn't installed", name)
+		}
+
+		selected = append(selected, module)
+	}
+
+	m.modules = selected
+	return nil
+}
+
+// Exclude removes the registered modules with the given names.
+func (m *ModuleSet) Exclude(names []string) {
+	var kept []Module
+	for _, module := range m.modules {
+		excluded := false
+		for _, name := range names {
+			excluded = excluded || strings.ToLower(name) == module.Name()
+		}
+
+		if !excluded {
+			kept = append(kept, module)
+		}
+	}
+
+	m.modules = kept
+}
+
 func (m *ModuleSet) LoadAll() error {
 	if err := os.MkdirAll(m.configs, 0755); err != nil {
 		return err
@@ -63,18 +135,54 @@ func (m *ModuleSet) LoadAll() error {
 			return err
 		}
 
-		if err := module.Load(m.client); err != nil {
+		if err := m.Load(module); err != nil {
 			return err
 		}
 	}
 
-	m.client.CmdHook("privmsg", m.helpCmd)
-	m.client.CmdHook("privmsg", m.moduleCmd)
-	m.client.CmdHook("privmsg", m.modulesCmd)
+	return nil
+}
+
+// Load loads the given module, which must already be configured, and
+// registers the commands it provides.
+func (m *ModuleSet) Load(module Module) error {
+	if err := module.Load(m.client); err != nil {
+		return err
+	}
+
+	commander, ok := module.(Commander)
+	if !ok {
+		return nil
+	}
+
+	for _, cmd := range commander.Commands() {
+		if err := m.addCommand(module.Name(), cmd); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
+// UnloadAll calls Unload on all modules implementing the Unloader
+// interface. All modules are unloaded even if unloading one of them
+// fails, the first error is returned.
+func (m *ModuleSet) UnloadAll(ctx context.Context) error {
+	var err error
+	for _, module := range m.modules {
+		unloader, ok := module.(Unloader)
+		if !ok {
+			continue
+		}
+
+		if uerr := unloader.Unload(ctx); uerr != nil && err == nil {
+			err = fmt.Errorf("%s: %s", module.Name(), uerr)
+		}
+	}
+
+	return err
+}
+
 func (m *ModuleSet) findModule(name string) Module {
 	for _, module := range m.modules {
 		if module.Name() == name {
@@ -85,44 +193,87 @@ func (m *ModuleSet) findModule(name string) Module {
 	return nil
 }
 
-func (m *ModuleSet) helpCmd(client *irc.Client, msg irc.Message) error {
-	if msg.Data != "!help" {
-		return nil
+// builtins returns the commands provided by the ModuleSet itself.
+func (m *ModuleSet) builtins() []Command {
+	return []Command{
+		{
+			Name: "help",
+			Args: []Arg{{Name: "topic", Optional: true}},
+			Help: "Shows the usage of a command or the commands of a module",
+			Run:  m.helpCmd,
+		},
+		{
+			Name: "more",
+			Help: "Shows more lines of the last reply",
+			Run:  m.moreCmd,
+		},
+		{
+			Name: "modules",
+			Help: "Lists all modules",
+			Run:  m.modulesCmd,
+		},
 	}
-
-	return client.Write("NOTICE %s :%s", msg.Receiver,
-		"Use !help MODULE to see the help message for the given module, use !modules to list all modules.")
 }
 
-func (m *ModuleSet) modulesCmd(client *irc.Client, msg irc.Message) error {
-	if msg.Data != "!modules" || len(m.modules) <= 0 {
-		return nil
+func (m *ModuleSet) helpCmd(client *irc.Client, msg irc.Message, args Args) error {
+	prefix := m.prefix(client, msg.Receiver)
+	if !args.Has("topic") {
+		var names []string
+		for _, r := range m.ordered {
+			names = append(names, prefix+r.command.Name)
+		}
+
+		return client.Reply(msg, "Available commands: %s. Use %shelp COMMAND or %shelp MODULE for details.",
+			strings.Join(names, ", "), prefix, prefix)
 	}
 
-	var names []string
-	for _, module := range m.modules {
-		names = append(names, module.Name())
+	topic := args.String("topic")
+	if cmd := m.lookup(topic, m.prefixes(client, msg.Receiver), true); cmd != nil {
+		return client.Reply(msg, "USAGE: %s (%s)", cmd.Usage(prefix), cmd.Help)
 	}
 
-	help := fmt.Sprintf("The following modules are available: %s",
-		strings.Join(names, ", "))
+	name := strings.ToLower(topic)
+	module := m.findModule(name)
+	if module == nil {
+		return client.Reply(msg, "Neither a command nor a module named %q exists", topic)
+	}
+
+	var usages []string
+	for _, r := range m.ordered {
+		if r.module == module.Name() {
+			usages = append(usages, r.command.Usage(prefix))
+		}
+	}
+
+	if len(usages) <= 0 {
+		return client.Reply(msg, "%s: %s", module.Name(), module.Help())
+	}
 
-	return client.Write("NOTICE %s :%s", msg.Receiver, help)
+	return client.Reply(msg, "%s: %s USAGE: %s", module.Name(), module.Help(),
+		strings.Join(usages, " || "))
 }
 
-func (m *ModuleSet) moduleCmd(client *irc.Client, msg irc.Message) error {
-	splited := strings.Fields(msg.Data)
-	if len(splited) < 2 || splited[0] != "!help" {
-		return nil
+func (m *ModuleSet) moreCmd(client *irc.Client, msg irc.Message, args Args) error {
+	err := client.More(client.ReplyTarget(msg))
+	if err == irc.ErrNoMore {
+		return client.Reply(msg, "There are no more lines to display.")
 	}
 
-	name := strings.ToLower(splited[1])
-	module := m.findModule(name)
-	if module == nil {
-		return client.Write("NOTICE %s :Module %q isn't installed",
-			msg.Receiver, name)
+	return err
+}
+
+func (m *ModuleSet) modulesCmd(client *irc.Client, msg irc.Message, args Args) error {
+	if len(m.modules) <= 0 {
+		return client.Reply(msg, "No modules are loaded.")
 	}
 
-	return client.Write("NOTICE %s :%s: %s",
-		msg.Receiver, module.Name(), module.Help())
+	var names []string
+	for _, module := range m.modules {
+		names = append(names, module.Name())
+	}
+
+	help := fmt.Sprintf("The following modules are available: %s",
+		strings.Join(names, ", "))
+
+	return client.Reply(msg, "%s", help)
 }
@@ -23,6 +23,7 @@ type Module struct {
 	NickServ string `json:"nickserv"`
 	Password string `json:"password"`
 	Keyword  string `json:"keyword"`
+	Regain   string `json:"regain"`
 }
 
 func Init(moduleSet *modules.ModuleSet) {
@@ -45,10 +46,12 @@ func (m *Module) Defaults() {
 func (m *Module) Load(client *irc.Client) error {
 	if len(m.Password) <= 0 {
 		return nil
+	} else if client.Recorder != nil {
+		client.Recorder.Redact(m.Password)
 	}
 
 	client.CmdHook("notice", func(c *irc.Client, msg irc.Message) error {
-		if msg.Sender.Name != m.NickServ || !strings.Contains(msg.Data, m.Keyword) {
+		if !c.EqualFold(msg.Sender.Name, m.NickServ) || !strings.Contains(msg.Data, m.Keyword) {
 			return nil
 		}
 
@@ -56,5 +59,19 @@ func (m *Module) Load(client *irc.Client) error {
 			m.NickServ, m.Password)
 	})
 
+	if len(m.Regain) >= 1 {
+		client.CmdHook("001", m.regainCmd)
+	}
+
 	return nil
 }
+
+func (m *Module) regainCmd(client *irc.Client, msg irc.Message) error {
+	nick := client.PrimaryNick()
+	if client.EqualFold(client.Nick(), nick) {
+		return nil
+	}
+
+	return client.Write("PRIVMSG %s :%s %s %s",
+		m.NickServ, m.Regain, nick, m.Password)
+}
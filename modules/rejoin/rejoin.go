@@ -16,7 +16,6 @@ package rejoin
 import (
 	"github.com/nmeum/marvin/irc"
 	"github.com/nmeum/marvin/modules"
-	"strings"
 	"time"
 )
 
@@ -47,12 +46,12 @@ func (m *Module) Load(client *irc.Client) error {
 	}
 
 	client.CmdHook("kick", func(c *irc.Client, msg irc.Message) error {
-		if msg.Data != client.Nickname {
+		if !client.EqualFold(msg.Param(1), client.Nick()) {
 			return nil
 		}
 
 		time.Sleep(duration)
-		return c.Write("JOIN %s", strings.Fields(msg.Receiver)[0])
+		return c.Write("JOIN %s", msg.Receiver)
 	})
 
 	return nil
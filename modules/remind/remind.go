@@ -14,13 +14,17 @@
 package remind
 
 import (
+	"context"
 	"github.com/nmeum/marvin/irc"
 	"github.com/nmeum/marvin/modules"
-	"strings"
+	"sync"
 	"time"
 )
 
 type Module struct {
+	mtx       sync.Mutex
+	users     map[string]int
+	timers    map[*time.Timer]bool
 	TimeLimit int `json:"time_limit"`
 	UserLimit int `json:"user_limit"`
 }
@@ -34,7 +38,7 @@ func (m *Module) Name() string {
 }
 
 func (m *Module) Help() string {
-	return "USAGE: !remind DURATION MSG"
+	return "Sends reminders after a given duration."
 }
 
 func (m *Module) Defaults() {
@@ -43,40 +47,74 @@ func (m *Module) Defaults() {
 }
 
 func (m *Module) Load(client *irc.Client) error {
-	users := make(map[string]int)
-	client.CmdHook("privmsg", func(c *irc.Client, msg irc.Message) error {
-		splited := strings.Fields(msg.Data)
-		if len(splited) < 3 || splited[0] != "!remind" {
-			return nil
-		}
-
-		duration, err := time.ParseDuration(splited[1])
-		if err != nil {
-			return c.Write("NOTICE %s :ERROR: %s", msg.Receiver, err.Error())
-		}
-
-		limit := time.Duration(m.TimeLimit) * time.Hour
-		if duration > limit {
-			return c.Write("NOTICE %s :%v hours exceeds the limit of %v hours",
-				msg.Receiver, duration.Hours(), limit.Hours())
-		}
-
-		if users[msg.Sender.Host] >= m.UserLimit {
-			return c.Write("NOTICE %s :You can only run %d reminders at a time",
-				msg.Receiver, m.UserLimit)
-		}
-
-		users[msg.Sender.Host]++
-		reminder := strings.Join(splited[2:], " ")
-		time.AfterFunc(duration, func() {
-			users[msg.Sender.Host]--
-			c.Write("PRIVMSG %s :Reminder: %s",
-				msg.Sender.Name, reminder)
-		})
-
-		return c.Write("NOTICE %s :Reminder setup for %s",
-			msg.Receiver, duration.String())
-	})
+	m.users = make(map[string]int)
+	m.timers = make(map[*time.Timer]bool)
+
+	return nil
+}
+
+func (m *Module) Commands() []modules.Command {
+	return []modules.Command{
+		{
+			Name: "remind",
+			Args: []modules.Arg{
+				{Name: "duration", Type: modules.ArgDuration},
+				{Name: "message", Type: modules.ArgRest},
+			},
+			Help: "Sends you the message after the given duration",
+			Run:  m.remindCmd,
+		},
+	}
+}
+
+func (m *Module) Unload(ctx context.Context) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for timer := range m.timers {
+		timer.Stop()
+	}
 
+	m.timers = make(map[*time.Timer]bool)
 	return nil
 }
+
+func (m *Module) remindCmd(client *irc.Client, msg irc.Message, args modules.Args) error {
+	duration := args.Duration("duration")
+
+	limit := time.Duration(m.TimeLimit) * time.Hour
+	if duration > limit {
+		return client.Reply(msg, "%v hours exceeds the limit of %v hours",
+			duration.Hours(), limit.Hours())
+	}
+
+	// Users without an account are limited by host.
+	user := "account:" + msg.Account
+	if len(msg.Account) <= 0 {
+		user = "host:" + msg.Sender.Host
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.users[user] >= m.UserLimit {
+		return client.Reply(msg, "You can only run %d reminders at a time",
+			m.UserLimit)
+	}
+
+	m.users[user]++
+	reminder := args.String("message")
+
+	var timer *time.Timer
+	timer = time.AfterFunc(duration, func() {
+		m.mtx.Lock()
+		m.users[user]--
+		delete(m.timers, timer)
+		m.mtx.Unlock()
+
+		client.Say(msg.Sender.Name, "Reminder: "+reminder)
+	})
+	m.timers[timer] = true
+
+	return client.Reply(msg, "Reminder setup for %s", duration.String())
+}
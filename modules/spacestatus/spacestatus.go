@@ -17,6 +17,7 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/nmeum/marvin/irc"
+	"github.com/nmeum/marvin/irc/format"
 	"github.com/nmeum/marvin/modules"
 	"io/ioutil"
 	"net/http"
@@ -39,6 +40,7 @@ type Module struct {
 	URL      string `json:"url"`
 	Notify   bool   `json:"notify"`
 	Interval string `json:"interval"`
+	format.Style
 }
 
 func Init(moduleSet *modules.ModuleSet) {
@@ -50,7 +52,7 @@ func (m *Module) Name() string {
 }
 
 func (m *Module) Help() string {
-	return "USAGE: !spacestatus"
+	return "Displays the door status of a hackerspace."
 }
 
 func (m *Module) Defaults() {
@@ -78,15 +80,28 @@ func (m *Module) Load(client *irc.Client) error {
 
 	go func(c *irc.Client) {
 		for {
-			time.Sleep(duration)
-			m.updateHandler(c)
+			select {
+			case <-time.After(duration):
+				m.updateHandler(c)
+			case <-c.Context().Done():
+				return
+			}
 		}
 	}(client)
 
-	client.CmdHook("privmsg", m.statusCmd)
 	return nil
 }
 
+func (m *Module) Commands() []modules.Command {
+	if len(m.URL) <= 0 {
+		return nil
+	}
+
+	return []modules.Command{
+		{Name: "spacestatus", Help: "Shows whether the space is open", Run: m.statusCmd},
+	}
+}
+
 func (m *Module) updateHandler(client *irc.Client) error {
 	var oldState bool
 	if m.api == nil {
@@ -127,37 +142,32 @@ func (m *Module) pollStatus() error {
 	return nil
 }
 
-func (m *Module) statusCmd(client *irc.Client, msg irc.Message) error {
-	if msg.Data != "!spacestatus" {
-		return nil
-	} else if m.api == nil {
-		return client.Write("NOTICE %s :Status currently unknown.",
-			msg.Receiver)
-	}
-
-	var state string
-	if m.api.State.Open {
-		state = "open"
-	} else {
-		state = "closed"
+func (m *Module) statusCmd(client *irc.Client, msg irc.Message, args modules.Args) error {
+	if m.api == nil {
+		return client.Reply(msg, "Status currently unknown.")
 	}
 
-	return client.Write("NOTICE %s :%s is currently %s",
-		msg.Receiver, m.api.Space, state)
+	return client.Reply(msg, "%s is currently %s",
+		m.api.Space, m.stateString(m.api.State.Open))
 }
 
 func (m *Module) notify(client *irc.Client, open bool) {
-	var oldState, newState string
-	if open {
-		oldState = "closed"
-		newState = "open"
-	} else {
-		oldState = "open"
-		newState = "closed"
-	}
+	oldState := m.stateString(!open)
+	newState := m.stateString(open)
 
-	for _, ch := range client.Channels {
-		client.Write("NOTICE %s :%s changed door status from %s to %s",
+	for _, ch := range client.Channels() {
+		client.WritePriority(irc.PriorityLow, "NOTICE %s :%s changed door status from %s to %s",
 			ch, m.api.Space, oldState, newState)
 	}
 }
+
+// stateString returns the given door status as colored text unless
+// plain output is configured.
+func (m *Module) stateString(open bool) string {
+	state, color := "closed", format.Red
+	if open {
+		state, color = "open", format.Green
+	}
+
+	return m.Style.Apply(format.Colorize(state, color))
+}
@@ -32,7 +32,7 @@ func (m *Module) Name() string {
 }
 
 func (m *Module) Help() string {
-	return "USAGE: !time"
+	return "Displays the current time."
 }
 
 func (m *Module) Defaults() {
@@ -40,16 +40,16 @@ func (m *Module) Defaults() {
 }
 
 func (m *Module) Load(client *irc.Client) error {
-	client.CmdHook("privmsg", m.timeCmd)
 	return nil
 }
 
-func (m *Module) timeCmd(client *irc.Client, msg irc.Message) error {
-	if msg.Data != "!time" {
-		return nil
+func (m *Module) Commands() []modules.Command {
+	return []modules.Command{
+		{Name: "time", Help: "Shows the current time in UTC", Run: m.timeCmd},
 	}
+}
 
+func (m *Module) timeCmd(client *irc.Client, msg irc.Message, args modules.Args) error {
 	now := time.Now().UTC()
-	return client.Write("NOTICE %s :%s",
-		msg.Receiver, now.Format(m.Format))
+	return client.Reply(msg, "%s", now.Format(m.Format))
 }
@@ -46,7 +46,7 @@ func (m *Module) Name() string {
 }
 
 func (m *Module) Help() string {
-	return "USAGE: !tweet TEXT || !reply ID @HANDLE TEXT || !directmsg USER TEXT || !retweet ID || !favorite ID || !stat ID"
+	return "Interacts with a Twitter account."
 }
 
 func (m *Module) Defaults() {
@@ -58,15 +58,6 @@ func (m *Module) Load(client *irc.Client) error {
 	anaconda.SetConsumerSecret(m.ConsumerSecret)
 
 	m.api = anaconda.NewTwitterApi(m.AccessToken, m.AccessTokenSecret)
-	client.CmdHook("privmsg", m.statCmd)
-
-	if !m.ReadOnly {
-		client.CmdHook("privmsg", m.tweetCmd)
-		client.CmdHook("privmsg", m.replyCmd)
-		client.CmdHook("privmsg", m.retweetCmd)
-		client.CmdHook("privmsg", m.favoriteCmd)
-		client.CmdHook("privmsg", m.directMsgCmd)
-	}
 
 	values := url.Values{}
 	values.Add("skip_status", "true")
@@ -83,7 +74,7 @@ func (m *Module) Load(client *irc.Client) error {
 	values.Add("with", "user")
 
 	go func(c *irc.Client, v url.Values) {
-		for {
+		for c.Context().Err() == nil {
 			m.streamHandler(c, v)
 		}
 	}(client, values)
@@ -91,137 +82,119 @@ func (m *Module) Load(client *irc.Client) error {
 	return nil
 }
 
+// Commands are only accepted from members of the channels the client
+// is in.
+func (m *Module) Commands() []modules.Command {
+	id := modules.Arg{Name: "id", Type: modules.ArgInt}
+	text := modules.Arg{Name: "text", Type: modules.ArgRest}
+	member := modules.LevelMember
+
+	cmds := []modules.Command{
+		{Name: "stat", Args: []modules.Arg{id}, Level: member,
+			Help: "Shows statistics of a tweet", Run: m.statCmd},
+	}
+
+	if !m.ReadOnly {
+		cmds = append(cmds, []modules.Command{
+			{Name: "tweet", Args: []modules.Arg{text}, Level: member,
+				Help: "Posts a tweet", Run: m.tweetCmd},
+			{Name: "reply", Args: []modules.Arg{id, text}, Level: member,
+				Help: "Replies to a tweet, the text must contain an @mention", Run: m.replyCmd},
+			{Name: "retweet", Args: []modules.Arg{id}, Level: member,
+				Help: "Retweets a tweet", Run: m.retweetCmd},
+			{Name: "favorite", Args: []modules.Arg{id}, Level: member,
+				Help: "Favorites a tweet", Run: m.favoriteCmd},
+			{Name: "directmsg", Args: []modules.Arg{{Name: "user"}, text}, Level: member,
+				Help: "Sends a direct message to a user", Run: m.directMsgCmd},
+		}...)
+	}
+
+	return cmds
+}
+
 func (m *Module) tweet(t string, v url.Values, c *irc.Client, p irc.Message) error {
 	_, err := m.api.PostTweet(t, v)
 	if err != nil && len(t) > maxChars {
-		return c.Write("NOTICE %s :ERROR: Tweet is too long, remove %d characters",
-			p.Receiver, len(t)-maxChars)
+		return c.Reply(p, "ERROR: Tweet is too long, remove %d characters",
+			len(t)-maxChars)
 	} else if err != nil {
-		return c.Write("NOTICE %s :ERROR: %s", p.Receiver, err.Error())
+		return c.Reply(p, "ERROR: %s", err.Error())
 	} else {
 		return nil
 	}
 }
 
-func (m *Module) tweetCmd(client *irc.Client, msg irc.Message) error {
-	splited := strings.Fields(msg.Data)
-	if len(splited) < 2 || splited[0] != "!tweet" || !client.Connected(msg.Receiver) {
-		return nil
-	}
-
-	status := strings.Join(splited[1:], " ")
-	return m.tweet(status, url.Values{}, client, msg)
+func (m *Module) tweetCmd(client *irc.Client, msg irc.Message, args modules.Args) error {
+	return m.tweet(args.String("text"), url.Values{}, client, msg)
 }
 
-func (m *Module) replyCmd(client *irc.Client, msg irc.Message) error {
-	splited := strings.Fields(msg.Data)
-	if len(splited) < 3 || splited[0] != "!reply" || !client.Connected(msg.Receiver) {
-		return nil
-	}
-
-	status := strings.Join(splited[2:], " ")
+func (m *Module) replyCmd(client *irc.Client, msg irc.Message, args modules.Args) error {
+	status := args.String("text")
 	if !strings.Contains(status, "@") {
-		return client.Write("NOTICE %s :ERROR: %s",
-			msg.Receiver, "A reply must contain an @mention")
+		return client.Reply(msg, "ERROR: A reply must contain an @mention")
 	}
 
 	values := url.Values{}
-	values.Add("in_reply_to_status_id", splited[1])
+	values.Add("in_reply_to_status_id", strconv.FormatInt(args.Int("id"), 10))
 
 	return m.tweet(status, values, client, msg)
 }
 
-func (m *Module) retweetCmd(client *irc.Client, msg irc.Message) error {
-	splited := strings.Fields(msg.Data)
-	if len(splited) < 2 || splited[0] != "!retweet" || !client.Connected(msg.Receiver) {
-		return nil
-	}
-
-	id, err := strconv.Atoi(splited[1])
-	if err != nil {
-		return err
-	}
-
-	if _, err := m.api.Retweet(int64(id), false); err != nil {
-		return client.Write("NOTICE %s :ERROR: %s",
-			msg.Receiver, err.Error())
+func (m *Module) retweetCmd(client *irc.Client, msg irc.Message, args modules.Args) error {
+	if _, err := m.api.Retweet(args.Int("id"), false); err != nil {
+		return client.Reply(msg, "ERROR: %s", err.Error())
 	}
 
 	return nil
 }
 
-func (m *Module) favoriteCmd(client *irc.Client, msg irc.Message) error {
-	splited := strings.Fields(msg.Data)
-	if len(splited) < 2 || splited[0] != "!favorite" || !client.Connected(msg.Receiver) {
-		return nil
-	}
-
-	id, err := strconv.Atoi(splited[1])
-	if err != nil {
-		return err
-	}
-
-	if _, err := m.api.Favorite(int64(id)); err != nil {
-		return client.Write("NOTICE %s :ERROR: %s",
-			msg.Receiver, err.Error())
+func (m *Module) favoriteCmd(client *irc.Client, msg irc.Message, args modules.Args) error {
+	if _, err := m.api.Favorite(args.Int("id")); err != nil {
+		return client.Reply(msg, "ERROR: %s", err.Error())
 	}
 
 	return nil
 }
 
-func (m *Module) directMsgCmd(client *irc.Client, msg irc.Message) error {
-	splited := strings.Fields(msg.Data)
-	if len(splited) < 3 || splited[0] != "!directmsg" || !client.Connected(msg.Receiver) {
-		return nil
-	}
-
-	scname := splited[1]
-	status := strings.Join(splited[2:], " ")
+func (m *Module) directMsgCmd(client *irc.Client, msg irc.Message, args modules.Args) error {
+	scname := args.String("user")
+	status := args.String("text")
 
 	if _, err := m.api.PostDMToScreenName(status, scname); err != nil {
-		return client.Write("NOTICE %s :ERROR: %s",
-			msg.Receiver, err.Error())
+		return client.Reply(msg, "ERROR: %s", err.Error())
 	}
 
 	return nil
 }
 
-func (m *Module) statCmd(client *irc.Client, msg irc.Message) error {
-	splited := strings.Fields(msg.Data)
-	if len(splited) < 2 || splited[0] != "!stat" || !client.Connected(msg.Receiver) {
-		return nil
-	}
-
-	id, err := strconv.Atoi(splited[1])
+func (m *Module) statCmd(client *irc.Client, msg irc.Message, args modules.Args) error {
+	tweet, err := m.api.GetTweet(args.Int("id"), url.Values{})
 	if err != nil {
-		return err
+		return client.Reply(msg, "ERROR: %s", err.Error())
 	}
 
-	tweet, err := m.api.GetTweet(int64(id), url.Values{})
-	if err != nil {
-		return err
-	}
-
-	return client.Write("NOTICE %s :Stats for tweet %d by %s: ↻ %d ★ %d",
-		msg.Receiver, tweet.Id, tweet.User.ScreenName, tweet.RetweetCount, tweet.FavoriteCount)
+	return client.Reply(msg, "Stats for tweet %d by %s: ↻ %d ★ %d",
+		tweet.Id, tweet.User.ScreenName, tweet.RetweetCount, tweet.FavoriteCount)
 }
 
 func (m *Module) streamHandler(client *irc.Client, values url.Values) {
 	stream := m.api.UserStream(values)
+	defer stream.Stop()
+
 	for {
 		select {
 		case event, ok := <-stream.C:
 			if !ok {
-				break
+				return
 			}
 
 			if t := m.formatEvent(event); len(t) > 0 {
 				m.notify(client, t)
 			}
+		case <-client.Context().Done():
+			return
 		}
 	}
-
-	stream.Stop()
 }
 
 func (m *Module) formatEvent(event interface{}) string {
@@ -255,7 +228,7 @@ func (m *Module) formatEvent(event interface{}) string {
 }
 
 func (m *Module) notify(client *irc.Client, text string) {
-	for _, ch := range client.Channels {
-		client.Write("NOTICE %s :%s", ch, text)
+	for _, ch := range client.Channels() {
+		client.Send(irc.PriorityLow, "NOTICE", ch, text)
 	}
 }
@@ -76,7 +76,7 @@ func (m *Module) urlCmd(client *irc.Client, msg irc.Message) error {
 		return nil
 	}
 
-	return client.Write("NOTICE %s :%s", msg.Receiver, info)
+	return client.Reply(msg, "%s", info)
 }
 
 func (m *Module) infoString(resp *http.Response) string {
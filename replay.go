@@ -0,0 +1,125 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/nmeum/marvin/irc"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// printer writes the lines sent by replayed bots to a writer.
+// Credentials are removed from the lines using a recorder.
+type printer struct {
+	mtx      sync.Mutex
+	w        io.Writer
+	recorder *irc.Recorder
+	wg       sync.WaitGroup
+}
+
+// replayFile passes the inbound lines recorded to the given file to
+// bots for the given networks. Instead of sending lines to a server
+// the bots print them to the given writer, without credentials.
+// Modules contacting other services aren't loaded, see onlineModules.
+func replayFile(path string, networks []config, w io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	records, err := irc.ReadRecords(file)
+	if err != nil {
+		return err
+	}
+
+	// The recording itself is discarded, the recorder is only
+	// used for removing credentials from the printed lines.
+	p := &printer{w: w, recorder: irc.NewRecorder(ioutil.Discard)}
+
+	group := irc.NewGroup()
+	bots := make(map[string]*bot)
+	for _, config := range networks {
+		conn, sink := net.Pipe()
+		p.wg.Add(1)
+		go p.printLines(config.Network, sink)
+
+		config.exclude = onlineModules
+		b, err := setup(conn, config, group, p.recorder)
+		if err != nil {
+			return err
+		}
+
+		b.client.SetLimits(irc.Limits{})
+		bots[config.Network] = b
+	}
+
+	errChan := make(chan error)
+	go func() {
+		logger := log.New(os.Stderr, "ERROR: ", 0)
+		for err := range errChan {
+			logger.Println(err)
+		}
+	}()
+
+	for _, record := range records {
+		if record.Direction != irc.Inbound {
+			continue
+		}
+
+		b, ok := bots[record.Network]
+		if !ok && len(networks) == 1 {
+			b = bots[networks[0].Network]
+		} else if !ok {
+			continue
+		}
+
+		// Wait for the hooks to make the replay deterministic.
+		b.client.Handle(record.Line, errChan)
+		b.client.Wait()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	for _, b := range bots {
+		b.quit(ctx)
+	}
+
+	p.wg.Wait()
+	return nil
+}
+
+// printLines prints all lines read from the given connection until
+// the connection is closed.
+func (p *printer) printLines(network string, conn net.Conn) {
+	defer p.wg.Done()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+
+		p.mtx.Lock()
+		fmt.Fprintf(p.w, "%s %s %s\n", network, irc.Outbound, p.recorder.Redacted(line))
+		p.mtx.Unlock()
+	}
+}
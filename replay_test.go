@@ -0,0 +1,68 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/nmeum/marvin/irc"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReplayRedactsCredentials(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "session.log")
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := irc.NewRecorder(file)
+	for _, line := range []string{
+		":irc.example.org CAP * LS :sasl",
+		":irc.example.org CAP * ACK :sasl",
+		"AUTHENTICATE +",
+		":irc.example.org 903 marvin :SASL authentication successful",
+	} {
+		recorder.Record("example", irc.Inbound, line)
+	}
+	file.Close()
+
+	conf := confDefaults()
+	conf.Network = "example"
+	conf.Conf = dir
+	conf.SASLUser = "marvin"
+	conf.SASLPass = "hunter2"
+
+	var out bytes.Buffer
+	if err := replayFile(path, []config{conf}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	output := out.String()
+	if !strings.Contains(output, "AUTHENTICATE PLAIN") {
+		t.Fatalf("SASL wasn't replayed:\n%s", output)
+	}
+
+	payload := "marvin\x00marvin\x00hunter2"
+	for _, secret := range []string{"hunter2", base64.StdEncoding.EncodeToString([]byte(payload))} {
+		if strings.Contains(output, secret) {
+			t.Errorf("output contains %q:\n%s", secret, output)
+		}
+	}
+}
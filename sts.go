@@ -0,0 +1,115 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type stsPolicy struct {
+	Port    int       `json:"port"`
+	Expires time.Time `json:"expires"`
+}
+
+// stsStore stores the STS policies received on secure connections in
+// a file. Additionally, it remembers the ports advertised on insecure
+// connections until the process exits.
+type stsStore struct {
+	mtx      sync.Mutex
+	path     string
+	policies map[string]stsPolicy
+	upgrades map[string]int
+}
+
+// Stores by path, shared between networks using the same file.
+var stsStores = make(map[string]*stsStore)
+
+func loadSTS(path string) (*stsStore, error) {
+	if store, ok := stsStores[path]; ok {
+		return store, nil
+	}
+
+	store := &stsStore{
+		path:     path,
+		policies: make(map[string]stsPolicy),
+		upgrades: make(map[string]int),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &store.policies); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	stsStores[path] = store
+	return store, nil
+}
+
+// endpoint returns the port to connect to for the given network and
+// whether TLS must be used.
+func (s *stsStore) endpoint(config config) (int, bool) {
+	host := strings.ToLower(config.Host)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if policy, ok := s.policies[host]; ok && time.Now().Before(policy.Expires) {
+		return policy.Port, true
+	} else if port, ok := s.upgrades[host]; ok {
+		return port, true
+	}
+
+	return config.Port, config.TLS || len(config.Cert) >= 1
+}
+
+// upgrade remembers the port advertised on an insecure connection.
+func (s *stsStore) upgrade(host string, port int) {
+	s.mtx.Lock()
+	s.upgrades[strings.ToLower(host)] = port
+	s.mtx.Unlock()
+}
+
+// update stores the policy received on a secure connection using the
+// given port. A zero duration removes the policy.
+func (s *stsStore) update(host string, port int, duration time.Duration) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	host = strings.ToLower(host)
+	if duration <= 0 {
+		delete(s.policies, host)
+	} else {
+		s.policies[host] = stsPolicy{port, time.Now().Add(duration)}
+	}
+
+	data, err := json.MarshalIndent(s.policies, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(s.path, data, 0600)
+}
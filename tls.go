@@ -0,0 +1,112 @@
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Prefix of pins containing the hash of the public key.
+const spkiPrefix = "sha256//"
+
+// tlsConfig creates the TLS configuration for the given network.
+func tlsConfig(config config) (*tls.Config, error) {
+	// A cert without tls only trusts the given CA certificates.
+	var pool *x509.CertPool
+	if len(config.Cert) >= 1 && !config.TLS {
+		pool = x509.NewCertPool()
+	} else if sys, err := x509.SystemCertPool(); err == nil {
+		pool = sys
+	} else {
+		pool = x509.NewCertPool()
+	}
+
+	files := config.CAFiles
+	if len(config.Cert) >= 1 {
+		files = append([]string{config.Cert}, files...)
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %q", file)
+		}
+	}
+
+	serverName := config.ServerName
+	if len(serverName) <= 0 {
+		serverName = config.Host
+	}
+
+	tlsConfig := &tls.Config{RootCAs: pool, ServerName: serverName}
+	if len(config.ClientCert) >= 1 && len(config.ClientKey) >= 1 {
+		clientCert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+
+	if len(config.Pins) >= 1 {
+		// Pinned certificates are often self-signed, thus the
+		// certificate is only compared with the pins.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyPins(state, config.Pins)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// verifyPins checks whether the certificate of the server matches one
+// of the given pins.
+func verifyPins(state tls.ConnectionState, pins []string) error {
+	if len(state.PeerCertificates) <= 0 {
+		return errors.New("server didn't send a certificate")
+	}
+
+	cert := state.PeerCertificates[0]
+	for _, pin := range pins {
+		if matchPin(cert, pin) {
+			return nil
+		}
+	}
+
+	return errors.New("server certificate doesn't match any pin")
+}
+
+func matchPin(cert *x509.Certificate, pin string) bool {
+	if strings.HasPrefix(pin, spkiPrefix) {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		return base64.StdEncoding.EncodeToString(sum[:]) == pin[len(spkiPrefix):]
+	}
+
+	sum := sha256.Sum256(cert.Raw)
+	fingerprint := strings.Replace(pin, ":", "", -1)
+	return strings.EqualFold(hex.EncodeToString(sum[:]), fingerprint)
+}